@@ -0,0 +1,209 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const completionDetails = `
+Install with one of:
+  ralphy completion bash > /etc/bash_completion.d/ralphy
+  ralphy completion zsh > "${fpath[1]}/_ralphy"
+  ralphy completion fish > ~/.config/fish/completions/ralphy.fish
+
+`
+
+type completionFlag struct {
+	name       string
+	usage      string
+	isBool     bool
+	repeatable bool
+}
+
+func completionCommand(args []string) int {
+	fs := newFlagSet("completion", completionDetails)
+	positional, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) != 1 {
+		return usageError(fs, "expected a shell: bash, zsh or fish")
+	}
+
+	switch positional[0] {
+	case "bash":
+		fmt.Print(bashCompletion())
+	case "zsh":
+		fmt.Print(zshCompletion())
+	case "fish":
+		fmt.Print(fishCompletion())
+	default:
+		return usageError(fs, "unsupported shell %q", positional[0])
+	}
+	return exitOK
+}
+
+// runFlagNames lists the options of 'ralphy run', which also apply when no
+// command is given.
+func runFlagNames() []completionFlag {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	defineRunFlags(fs)
+	defineLoopFlags(fs)
+
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		if len(f.Name) == 1 {
+			return
+		}
+		isBool := false
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok {
+			isBool = b.IsBoolFlag()
+		}
+		_, repeatable := f.Value.(*stringList)
+		flags = append(flags, completionFlag{name: f.Name, usage: f.Usage, isBool: isBool, repeatable: repeatable})
+	})
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+	return flags
+}
+
+func commandNames() []string {
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+func bashCompletion() string {
+	var b strings.Builder
+	var runFlags []string
+	for _, f := range runFlagNames() {
+		runFlags = append(runFlags, "--"+f.name)
+	}
+
+	b.WriteString("# bash completion for ralphy\n")
+	b.WriteString("_ralphy() {\n")
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    local cmd=\"${COMP_WORDS[1]}\"\n")
+	fmt.Fprintf(&b, "    local commands=%q\n", strings.Join(commandNames(), " "))
+	fmt.Fprintf(&b, "    local run_flags=%q\n\n", strings.Join(runFlags, " "))
+	b.WriteString("    if [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then\n")
+	b.WriteString("        COMPREPLY=($(compgen -W \"$commands\" -- \"$cur\"))\n")
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n\n")
+	b.WriteString("    local subcommands=\"\"\n")
+	b.WriteString("    case \"$cmd\" in\n")
+	for _, cmd := range commands {
+		if len(cmd.subcommands) > 0 {
+			fmt.Fprintf(&b, "        %s) subcommands=%q ;;\n", cmd.name, strings.Join(cmd.subcommands, " "))
+		}
+	}
+	fmt.Fprintf(&b, "        help) subcommands=%q ;;\n", strings.Join(commandNames(), " "))
+	b.WriteString("    esac\n")
+	b.WriteString("    if [[ $COMP_CWORD -eq 2 && -n $subcommands ]]; then\n")
+	b.WriteString("        COMPREPLY=($(compgen -W \"$subcommands\" -- \"$cur\"))\n")
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n\n")
+	b.WriteString("    if [[ $cur == -* ]]; then\n")
+	b.WriteString("        case \"$cmd\" in\n")
+	b.WriteString("            history) COMPREPLY=($(compgen -W \"-n --json --watch\" -- \"$cur\")) ;;\n")
+	b.WriteString("            status) COMPREPLY=($(compgen -W \"--tasks --json --watch\" -- \"$cur\")) ;;\n")
+	b.WriteString("            *) COMPREPLY=($(compgen -W \"$run_flags\" -- \"$cur\")) ;;\n")
+	b.WriteString("        esac\n")
+	b.WriteString("    fi\n")
+	b.WriteString("}\n")
+	b.WriteString("complete -o default -F _ralphy ralphy\n")
+	return b.String()
+}
+
+func zshCompletion() string {
+	var b strings.Builder
+	b.WriteString("#compdef ralphy\n\n")
+	b.WriteString("_ralphy() {\n")
+	b.WriteString("    local -a commands run_flags\n")
+	b.WriteString("    commands=(\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "        %s\n", zshQuote(cmd.name+":"+cmd.summary))
+	}
+	b.WriteString("    )\n")
+	b.WriteString("    run_flags=(\n")
+	for _, f := range runFlagNames() {
+		spec := "--" + f.name + "[" + strings.NewReplacer("[", "(", "]", ")", ":", "").Replace(f.usage) + "]"
+		if f.repeatable {
+			spec = "*" + spec
+		}
+		switch {
+		case f.name == "prompt-file" || f.name == "file":
+			spec += ":file:_files"
+		case !f.isBool:
+			spec += ":value:"
+		}
+		fmt.Fprintf(&b, "        %s\n", zshQuote(spec))
+	}
+	b.WriteString("    )\n\n")
+	b.WriteString("    if (( CURRENT == 2 )) && [[ $PREFIX != -* ]]; then\n")
+	b.WriteString("        _describe 'command' commands\n")
+	b.WriteString("        _files\n")
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n\n")
+	b.WriteString("    if (( CURRENT == 3 )); then\n")
+	b.WriteString("        case $words[2] in\n")
+	for _, cmd := range commands {
+		if len(cmd.subcommands) > 0 {
+			fmt.Fprintf(&b, "            %s) compadd %s; return ;;\n", cmd.name, strings.Join(cmd.subcommands, " "))
+		}
+	}
+	fmt.Fprintf(&b, "            help) compadd %s; return ;;\n", strings.Join(commandNames(), " "))
+	b.WriteString("        esac\n")
+	b.WriteString("    fi\n\n")
+	b.WriteString("    case $words[2] in\n")
+	b.WriteString("        history) _arguments '-n[Show only the last N iterations]:count:' '--json[Print JSON]' '--watch[Print again on every change]' ;;\n")
+	b.WriteString("        status) _arguments '--tasks[Show the task list]' '--json[Print JSON]' '--watch[Print again on every change]' ;;\n")
+	b.WriteString("        *) _arguments $run_flags '*:prompt or file:_files' ;;\n")
+	b.WriteString("    esac\n")
+	b.WriteString("}\n\n")
+	b.WriteString("compdef _ralphy ralphy\n")
+	return b.String()
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+	b.WriteString("# fish completion for ralphy\n")
+	b.WriteString("complete -c ralphy -f\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "complete -c ralphy -n __fish_use_subcommand -a %s -d %s\n", cmd.name, fishQuote(cmd.summary))
+	}
+	for _, cmd := range commands {
+		if len(cmd.subcommands) > 0 {
+			fmt.Fprintf(&b, "complete -c ralphy -n '__fish_seen_subcommand_from %s' -a %s\n", cmd.name, fishQuote(strings.Join(cmd.subcommands, " ")))
+		}
+	}
+	fmt.Fprintf(&b, "complete -c ralphy -n '__fish_seen_subcommand_from help' -a %s\n", fishQuote(strings.Join(commandNames(), " ")))
+	b.WriteString("complete -c ralphy -n '__fish_seen_subcommand_from history' -s n -r -d 'Show only the last N iterations'\n")
+	b.WriteString("complete -c ralphy -n '__fish_seen_subcommand_from status' -l tasks -d 'Show the task list'\n")
+	b.WriteString("complete -c ralphy -n '__fish_seen_subcommand_from status history' -l json -d 'Print JSON'\n")
+	b.WriteString("complete -c ralphy -n '__fish_seen_subcommand_from status history' -l watch -d 'Print again on every change'\n")
+
+	condition := "'__fish_use_subcommand; or __fish_seen_subcommand_from run config'"
+	for _, f := range runFlagNames() {
+		requires := " -r"
+		if f.isBool {
+			requires = ""
+		}
+		if f.name == "prompt-file" || f.name == "file" {
+			requires += " -F"
+		}
+		fmt.Fprintf(&b, "complete -c ralphy -n %s -l %s%s -d %s\n", condition, f.name, requires, fishQuote(f.usage))
+	}
+	return b.String()
+}
+
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
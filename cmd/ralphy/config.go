@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wltechblog/ralphy/internal/agent"
+	"github.com/wltechblog/ralphy/internal/config"
+	"github.com/wltechblog/ralphy/internal/loop"
+)
+
+// commandFlags select what a run does rather than how the loop behaves, so
+// config files and RALPHY_* variables cannot set them. The loop selection
+// flags read their variables themselves, for every command.
+var commandFlags = map[string]bool{
+	"state-dir":   true,
+	"name":        true,
+	"resume":      true,
+	"takeover":    true,
+	"prompt-file": true,
+	"file":        true,
+	"f":           true,
+	"profile":     true,
+}
+
+// applyConfig fills unset flags in fs from RALPHY_* variables, the project
+// config and the user config, in that order of precedence.
+func applyConfig(fs *flag.FlagSet, profile string) ([]config.Setting, error) {
+	if profile == "" {
+		profile = os.Getenv(config.ProfileEnv)
+	}
+
+	project, err := config.LoadAny(config.ProjectFileName, config.ProjectTOMLFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *config.File
+	if userPaths, err := config.UserConfigPaths(); err == nil {
+		user, err = config.LoadAny(userPaths...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !commandFlags[f.Name] {
+			names = append(names, f.Name)
+		}
+	})
+
+	return config.Apply(fs, names, profile, project, user)
+}
+
+func configCommand(args []string) int {
+	fs := newFlagSet("config", `
+Accepts the same options as 'ralphy run'; options given here show up with
+source "flag".
+
+`)
+	flags := defineRunFlags(fs)
+	positional, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) != 1 || positional[0] != "show" {
+		return usageError(fs, "expected 'ralphy config show'")
+	}
+
+	settings, err := applyConfig(fs, *flags.profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return exitError
+	}
+	opts, err := parseRunOptions(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	printConfig(opts.loopOptions(nil), settings, *flags.profile)
+	return exitOK
+}
+
+// resolvedOption is one of the loop options config show prints, with the
+// flags it is made from.
+type resolvedOption struct {
+	name  string
+	value string
+	flags []string
+}
+
+func resolvedOptions(o *loop.LoopOptions) []resolvedOption {
+	orNone := func(s string) string {
+		if s == "" {
+			return "none"
+		}
+		return s
+	}
+	orOff := func(d time.Duration) string {
+		if d == 0 {
+			return "off"
+		}
+		return d.String()
+	}
+	unlimited := func(n int) string {
+		if n == 0 {
+			return "unlimited"
+		}
+		return strconv.Itoa(n)
+	}
+
+	model := o.Model
+	if len(o.ModelChain) > 0 && !slices.Contains(o.ModelChain, model) {
+		model = o.ModelChain[0]
+	}
+	if model == "" {
+		model = "agent default"
+	}
+	agentName := o.Agent
+	if agentName == "" {
+		agentName = agent.DefaultBackend
+	}
+	budget := "none"
+	if o.Budget.IsSet() {
+		budget = o.Budget.Summary()
+	}
+	var warnAt []string
+	for _, percent := range o.Budget.WarnAt {
+		warnAt = append(warnAt, fmt.Sprintf("%d%%", percent))
+	}
+
+	return []resolvedOption{
+		{"MaxIterations", unlimited(o.MaxIterations), []string{"max-iterations"}},
+		{"CompletionPromise", o.CompletionPromise, []string{"completion-promise"}},
+		{"TaskPromise", o.TaskPromise, []string{"task-promise"}},
+		{"Model", model, []string{"model", "model-chain"}},
+		{"ModelChain", orNone(strings.Join(o.ModelChain, " → ")), []string{"model-chain"}},
+		{"EscalateAfter", strconv.Itoa(o.EscalateAfter), []string{"escalate-after"}},
+		{"DeescalateAfter", strconv.Itoa(o.DeescalateAfter), []string{"deescalate-after"}},
+		{"Agent", agentName, []string{"agent"}},
+		{"AgentCommand", orNone(o.AgentCommand), []string{"agent-command"}},
+		{"PromptVia", orNone(o.PromptVia), []string{"prompt-via"}},
+		{"ToolPattern", orNone(o.ToolPattern), []string{"tool-pattern"}},
+		{"JSONEvents", strconv.FormatBool(o.JSONEvents), []string{"json-events"}},
+		{"ServerURL", orNone(o.ServerURL), []string{"server-url"}},
+		{"ReuseSession", strconv.FormatBool(o.ReuseSession), []string{"reuse-session"}},
+		{"StreamOutput", strconv.FormatBool(o.StreamOutput), []string{"no-stream"}},
+		{"VerboseTools", strconv.FormatBool(o.VerboseTools), []string{"verbose-tools", "verbose"}},
+		{"DisablePlugins", strconv.FormatBool(o.DisablePlugins), []string{"no-plugins"}},
+		{"AutoCommit", strconv.FormatBool(o.AutoCommit), []string{"no-commit"}},
+		{"Branch", orNone(o.Branch), []string{"branch", "merge"}},
+		{"Merge", orNone(o.Merge), []string{"merge"}},
+		{"AllowAllPermissions", strconv.FormatBool(o.AllowAllPermissions), []string{"allow-all"}},
+		{"Verbose", strconv.FormatBool(o.Verbose), []string{"verbose"}},
+		{"Timeout", orOff(o.Timeout), []string{"timeout"}},
+		{"IterationTimeout", orOff(o.IterationTimeout), []string{"iteration-timeout"}},
+		{"RetryDelay", o.RetryDelay.String(), []string{"retry-delay"}},
+		{"RetryMaxDelay", o.RetryMaxDelay.String(), []string{"retry-max-delay"}},
+		{"MaxFailures", unlimited(o.MaxFailures), []string{"max-failures"}},
+		{"Budget", budget, []string{"max-duration", "max-cost", "max-tokens", "hard-budget"}},
+		{"BudgetWarnAt", orNone(strings.Join(warnAt, ", ")), []string{"budget-warn"}},
+		{"Checks", orNone(strings.Join(o.Checks, "; ")), []string{"check"}},
+		{"Verify", orNone(strings.Join(o.Verify, "; ")), []string{"verify"}},
+		{"Transcripts", strconv.FormatBool(o.Transcripts), []string{"no-transcripts"}},
+		{"CompressTranscripts", strconv.FormatBool(o.CompressTranscripts), []string{"compress-transcripts"}},
+	}
+}
+
+// printConfig prints the options a loop would run with and, for each, the
+// flags that set it and where their values came from.
+func printConfig(o *loop.LoopOptions, settings []config.Setting, profile string) {
+	sources := map[string]string{}
+	for _, setting := range settings {
+		sources[setting.Name] = setting.Source
+	}
+
+	fmt.Println("Effective loop options")
+	fmt.Println("────────────────────────────────────────────────────────────────────")
+	if profile == "" {
+		profile = os.Getenv(config.ProfileEnv)
+	}
+	if profile != "" {
+		fmt.Printf("Profile: %s\n\n", profile)
+	}
+
+	options := resolvedOptions(o)
+	width := 0
+	for _, option := range options {
+		width = max(width, len(option.name))
+	}
+	for _, option := range options {
+		var from []string
+		for _, name := range option.flags {
+			switch source := sources[name]; {
+			case source == "" || source == config.SourceDefault:
+			case source == config.SourceFlag:
+				from = append(from, "--"+name)
+			case strings.HasPrefix(source, "env "):
+				from = append(from, source)
+			default:
+				from = append(from, name+" in "+source)
+			}
+		}
+		source := config.SourceDefault
+		if len(from) > 0 {
+			source = strings.Join(from, ", ")
+		}
+		value := option.value
+		if value == "" {
+			value = `""`
+		}
+		fmt.Printf("%-*s  %-30s  (%s)\n", width, option.name, value, source)
+	}
+
+	fmt.Println("")
+	fmt.Printf("Precedence: flags > RALPHY_* env > %s or %s > user config\n", config.ProjectFileName, config.ProjectTOMLFileName)
+	if userPaths, err := config.UserConfigPaths(); err == nil {
+		fmt.Printf("User config: %s\n", strings.Join(userPaths, " or "))
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+const contextDetails = `
+Subcommands:
+  add TEXT            Add context for the next iteration
+                      (or edit .opencode/ralph-context.md)
+  clear               Clear any pending context
+  show                Print the pending context
+
+`
+
+func contextCommand(args []string) int {
+	fs := newFlagSet("context", contextDetails)
+	positional, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) == 0 {
+		return usageError(fs, "missing context subcommand")
+	}
+
+	switch positional[0] {
+	case "add":
+		text := strings.TrimSpace(strings.Join(positional[1:], " "))
+		if text == "" {
+			return usageError(fs, "context add requires a text argument")
+		}
+		if err := state.SaveContext(text); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving context: %v\n", err)
+			return exitError
+		}
+
+		fmt.Println("✅ Context added for next iteration")
+		if contextPath, err := state.GetContextPath(); err == nil {
+			fmt.Printf("   File: %s\n", contextPath)
+		}
+
+		existingState, _ := state.LoadState()
+		if existingState != nil && existingState.Active {
+			fmt.Printf("   Will be picked up in iteration %d\n", existingState.Iteration+1)
+		} else {
+			fmt.Println("   Will be used when loop starts")
+		}
+		return exitOK
+	case "clear":
+		if err := state.ClearContext(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error clearing context:", err)
+			return exitError
+		}
+		fmt.Println("✅ Context cleared")
+		return exitOK
+	case "show":
+		ctx, err := state.LoadContext()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading context:", err)
+			return exitError
+		}
+		if ctx == "" {
+			fmt.Println("No pending context")
+			return exitOK
+		}
+		fmt.Println(ctx)
+		return exitOK
+	}
+	return usageError(fs, "unknown context subcommand %q", positional[0])
+}
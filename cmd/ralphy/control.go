@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+// controlCommand returns the command that sends action (stop, pause,
+// unpause or abort) to the running loop.
+func controlCommand(action string) func(args []string) int {
+	return func(args []string) int {
+		fs := newFlagSet(action, "")
+		positional, code, ok := parseArgs(fs, args)
+		if !ok {
+			return code
+		}
+		if len(positional) > 0 {
+			return usageError(fs, "unexpected argument %q", positional[0])
+		}
+		return sendControl(action)
+	}
+}
+
+func sendControl(action string) int {
+	s, err := state.LoadState()
+	if err != nil || state.CheckOwner(s) != state.OwnerRunning {
+		fmt.Fprintln(os.Stderr, "Error: No running loop")
+		return exitError
+	}
+
+	if action == "unpause" {
+		req, _ := state.LoadControl()
+		if req == nil || req.Action != state.ControlPause {
+			fmt.Println("Loop is not paused")
+			return exitOK
+		}
+		if err := state.ClearControl(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error unpausing loop: %v\n", err)
+			return exitError
+		}
+		fmt.Println("▶️  Loop will continue")
+		return exitOK
+	}
+
+	var message string
+	switch action {
+	case state.ControlStop:
+		message = fmt.Sprintf("🛑 Loop will stop after iteration %d completes", s.Iteration)
+	case state.ControlAbort:
+		message = "🛑 Aborting loop"
+	default:
+		message = fmt.Sprintf("⏸️  Loop will pause before iteration %d", s.Iteration+1)
+	}
+
+	if err := state.RequestControl(action); err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending %s request: %v\n", action, err)
+		return exitError
+	}
+	fmt.Println(message)
+	return exitOK
+}
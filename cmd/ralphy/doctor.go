@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+func doctorCommand(args []string) int {
+	fs := newFlagSet("doctor", "")
+	fix := fs.Bool("fix", false, "Repair the problems found")
+	positional, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 0 {
+		return usageError(fs, "unexpected argument %q", positional[0])
+	}
+
+	if *fix && state.LoopLockHeld() {
+		running := "A loop is running"
+		if owner := state.LoopLockOwner(); owner != "" {
+			running += " (" + owner + ")"
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s; stop it before running 'ralphy doctor --fix'\n", running)
+		return exitError
+	}
+
+	findings, err := state.Doctor(*fix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking state: %v\n", err)
+		return exitError
+	}
+	if len(findings) == 0 {
+		fmt.Println("✅ No problems found")
+		return exitOK
+	}
+
+	unresolved, repairable := 0, 0
+	for _, f := range findings {
+		if f.Fixed {
+			fmt.Printf("✅ %s: %s\n   Fixed: %s\n", f.File, f.Problem, f.Action)
+			continue
+		}
+		unresolved++
+		if f.Repairable {
+			repairable++
+		}
+		fmt.Printf("❌ %s: %s\n", f.File, f.Problem)
+		if f.Action != "" {
+			fmt.Printf("   Fix: %s\n", f.Action)
+		}
+	}
+
+	if unresolved == 0 {
+		return exitOK
+	}
+	if !*fix && repairable > 0 {
+		fmt.Println("\nRepair with: ralphy doctor --fix")
+	}
+	return exitError
+}
+
+// warnCorrupt reports a state file that exists but cannot be used.
+func warnCorrupt(err error) {
+	fmt.Printf("⚠️  %v\n", err)
+	fmt.Println("   Inspect it with: ralphy doctor")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/wltechblog/ralphy/internal/state"
+	"github.com/wltechblog/ralphy/internal/tools"
+)
+
+func historyCommand(args []string) int {
+	fs := newFlagSet("history", "")
+	limit := fs.Int("n", 0, "Show only the last N iterations")
+	jsonOutput := fs.Bool("json", false, "Print the history as JSON")
+	watchMode := fs.Bool("watch", false, "Keep running and print the history again whenever it changes")
+	fromEvents := fs.Bool("from-events", false, "Rebuild the history from ralph-events.jsonl instead of reading ralph-history.json")
+	positional, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 0 {
+		return usageError(fs, "unexpected argument %q", positional[0])
+	}
+
+	render := func() {
+		if *jsonOutput {
+			if err := printJSON(buildHistoryReport(*limit, *fromEvents), *watchMode); err != nil {
+				fmt.Fprintf(os.Stderr, "Error encoding history: %v\n", err)
+			}
+			return
+		}
+		if *watchMode {
+			fmt.Print("\033[H\033[2J")
+		}
+		printHistory(*limit, *fromEvents)
+	}
+
+	if *watchMode {
+		watch(render)
+	}
+	render()
+	return exitOK
+}
+
+func printHistory(limit int, fromEvents bool) {
+
+	h, err := loadHistory(fromEvents)
+	if err != nil && !errors.Is(err, state.ErrStateNotFound) {
+		warnCorrupt(err)
+		return
+	}
+	if err != nil || (len(h.Iterations) == 0 && len(h.AgentFailures) == 0) {
+		fmt.Println("No iterations recorded")
+		return
+	}
+	printIterations(h, limit)
+}
+
+// loadHistory reads the current loop's history, or replays it from the
+// event log when fromEvents is set.
+func loadHistory(fromEvents bool) (*state.RalphHistory, error) {
+	if !fromEvents {
+		return state.LoadHistory()
+	}
+	s, err := state.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	return state.RebuildHistory(s.RunID)
+}
+
+func printIterations(h *state.RalphHistory, limit int) {
+	iterations := h.Iterations
+	if limit > 0 && len(iterations) > limit {
+		iterations = iterations[len(iterations)-limit:]
+	}
+
+	total := tools.FormatDurationLong(h.TotalDurationMs)
+	if h.TotalCostUSD > 0 {
+		total += ", " + tools.FormatCost(h.TotalCostUSD)
+	}
+	fmt.Printf("📊 HISTORY (%d iterations, %s total)\n\n", len(h.Iterations), total)
+	showModels := usesSeveralModels(h)
+	for _, iter := range iterations {
+		status := "🔄"
+		if iter.CompletionDetected {
+			status = "✅"
+		} else if iter.CompletionRejected {
+			status = "🚫"
+		} else if iter.TimedOut {
+			status = "⏱️"
+		} else if iter.ExitCode != 0 {
+			status = "❌"
+		}
+
+		toolsSummary := tools.FormatToolSummary(iter.ToolsUsed, 4)
+		if toolsSummary == "" {
+			toolsSummary = "no tools"
+		}
+		fmt.Printf("%s #%d  %s  %s  exit %d  %d files  %s\n",
+			status, iter.Iteration, iter.StartedAt, tools.FormatDurationLong(iter.DurationMs),
+			iter.ExitCode, len(iter.FilesModified), toolsSummary)
+		if showModels && iter.Model != "" {
+			fmt.Printf("   model %s\n", iter.Model)
+		}
+		if iter.Tokens != nil {
+			usage := "   tokens " + iter.Tokens.Summary()
+			if iter.CostUSD > 0 {
+				usage += "  " + tools.FormatCost(iter.CostUSD)
+			}
+			fmt.Println(usage)
+		}
+		if len(iter.Checks) > 0 {
+			fmt.Printf("   checks %d/%d passed\n", countPassed(iter.Checks), len(iter.Checks))
+		}
+		if iter.CompletionRejected {
+			fmt.Println("   completion rejected by --verify")
+		}
+		for _, msg := range iter.Errors {
+			fmt.Printf("   ⚠️  %s\n", truncate(msg, 100))
+		}
+	}
+
+	failures := h.AgentFailures
+	if limit > 0 && len(failures) > limit {
+		failures = failures[len(failures)-limit:]
+	}
+	if len(failures) > 0 {
+		fmt.Printf("\n🔌 AGENT FAILURES (%d, retried without using up iterations)\n\n", len(h.AgentFailures))
+	}
+	for _, f := range failures {
+		outcome := "retried after " + tools.FormatDurationLong(f.RetryInMs)
+		if f.BreakerOpened {
+			outcome = "circuit breaker opened"
+		}
+		if f.FallbackModel != "" {
+			outcome = "fell back to " + f.FallbackModel
+		}
+		fmt.Printf("🔌 #%d  attempt %d  %s  %s  %s\n", f.Iteration, f.Attempt, f.At, f.Kind, outcome)
+		fmt.Printf("   %s\n", truncate(f.Error, 100))
+	}
+}
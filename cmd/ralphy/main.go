@@ -1,296 +1,320 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"strings"
-	"time"
 
-	"github.com/wltechblog/ralphy/internal/loop"
+	"github.com/wltechblog/ralphy/internal/config"
 	"github.com/wltechblog/ralphy/internal/state"
 )
 
-func main() {
-	flagHelp := flag.Bool("help", false, "Show help")
-	flagVersion := flag.Bool("version", false, "Show version")
-	flagStatus := flag.Bool("status", false, "Show Ralphy loop status")
-	addContext := flag.String("add-context", "", "Add context for next iteration")
-	flagClearContext := flag.Bool("clear-context", false, "Clear pending context")
-
-	taskPromise := flag.String("task-promise", "READY_FOR_NEXT_TASK", "Phrase that signals task completion")
-	listTasks := flag.Bool("list-tasks", false, "Display the current task list")
-	addTask := flag.String("add-task", "", "Add a new task to the list")
-	removeTask := flag.Int("remove-task", 0, "Remove task at index N")
-
-	maxIterations := flag.Int("max-iterations", 0, "Maximum iterations before stopping (default: unlimited)")
-	completionPromise := flag.String("completion-promise", "COMPLETE", "Phrase that signals completion")
-	model := flag.String("model", "", "Model to use (e.g., anthropic/claude-sonnet)")
-	promptFile := flag.String("prompt-file", "", "Read prompt content from a file")
-	noStream := flag.Bool("no-stream", false, "Buffer OpenCode output and print at the end")
-	verboseTools := flag.Bool("verbose-tools", false, "Print every tool line")
-	noPlugins := flag.Bool("no-plugins", false, "Disable non-auth OpenCode plugins")
-	noCommit := flag.Bool("no-commit", false, "Don't auto-commit after each iteration")
-	allowAll := flag.Bool("allow-all", false, "Auto-approve all tool permissions")
-	verbose := flag.Bool("verbose", false, "Show more verbose output from OpenCode")
-	timeoutStr := flag.String("timeout", "1h", "Timeout if no activity (e.g. 1h, 30m, 0 to disable)")
-
-	flag.Usage = func() {
-		fmt.Println(`
-Ralphy Wiggum Loop - Iterative AI development with OpenCode
+const (
+	exitOK    = 0
+	exitError = 1
+	// exitUsage is EX_USAGE from sysexits.h; the loop's own exit codes
+	// start at 2.
+	exitUsage = 64
+)
 
-Usage:
-  ralphy "<prompt>" [options]
-  ralphy --prompt-file <path> [options]
-
-Arguments:
-  prompt              Task description for the AI to work on
-
-Options:
-  --max-iterations N  Maximum iterations before stopping (default: unlimited)
-  --completion-promise TEXT  Phrase that signals completion (default: COMPLETE)
-  --task-promise TEXT Phrase that signals task completion (default: READY_FOR_NEXT_TASK)
-  --model MODEL       Model to use (e.g., anthropic/claude-sonnet)
-  --prompt-file, --file, -f  Read prompt content from a file
-  --no-stream         Buffer OpenCode output and print at the end
-  --verbose-tools     Print every tool line (disable compact tool summary)
-  --no-plugins        Disable non-auth OpenCode plugins for this run
-  --no-commit         Don't auto-commit after each iteration
-  --allow-all         Auto-approve all tool permissions (for non-interactive use)
-  --verbose           Show more verbose output from OpenCode
-  --timeout DUR       Timeout if no activity (default: 1h, 0 to disable)
-  --version, -v       Show version
-  --help, -h          Show this help
+type command struct {
+	name        string
+	usage       string
+	summary     string
+	subcommands []string
+	run         func(args []string) int
+}
 
-Commands:
-  --status            Show current ralphy loop status and history
-  --status --tasks    Show status including current task list
-  --add-context TEXT  Add context for the next iteration (or edit .opencode/ralph-context.md)
-  --clear-context     Clear any pending context
-  --list-tasks        Display the current task list
-  --add-task "desc"   Add a new task to the list
-  --remove-task N     Remove task at index N
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "run", usage: `run "<prompt>" [options]`, summary: "Start or resume a Ralph loop (the default command)", run: runCommand},
+		{name: "status", usage: "status [--all] [--json] [--watch]", summary: "Show the current loop, tasks and recent history", run: statusCommand},
+		{name: "history", usage: "history [-n N] [--json] [--watch] [--from-events]", summary: "List the iterations of the current loop", run: historyCommand},
+		{name: "tasks", usage: "tasks ls|add|rm|done", summary: "Manage the task list in .opencode/ralph-tasks.md", subcommands: []string{"ls", "add", "rm", "done"}, run: tasksCommand},
+		{name: "context", usage: "context add|clear|show", summary: "Manage context injected into the next iteration", subcommands: []string{"add", "clear", "show"}, run: contextCommand},
+		{name: "transcript", usage: "transcript [N] [--grep RE] [--diff M] [--run ID]", summary: "View, search and diff the prompt and output of an iteration", run: transcriptCommand},
+		{name: "runs", usage: "runs [ls|show <id>] [--json]", summary: "Browse archived runs in .opencode/ralph-runs", subcommands: []string{"ls", "show"}, run: runsCommand},
+		{name: "stop", usage: "stop", summary: "Finish the current iteration, commit, then stop the loop", run: controlCommand("stop")},
+		{name: "pause", usage: "pause", summary: "Hold the loop before its next iteration", run: controlCommand("pause")},
+		{name: "unpause", usage: "unpause", summary: "Continue a paused loop", run: controlCommand("unpause")},
+		{name: "abort", usage: "abort", summary: "Kill the running agent and stop the loop immediately", run: controlCommand("abort")},
+		{name: "prices", usage: "prices [--json]", summary: "Show the model price table used to estimate costs", run: pricesCommand},
+		{name: "doctor", usage: "doctor [--fix]", summary: "Check the .opencode state files and repair them", run: doctorCommand},
+		{name: "config", usage: "config show [--profile NAME]", summary: "Print the resolved loop options and where each came from", subcommands: []string{"show"}, run: configCommand},
+		{name: "completion", usage: "completion bash|zsh|fish", summary: "Print a shell completion script", subcommands: []string{"bash", "zsh", "fish"}, run: completionCommand},
+		{name: "version", usage: "version", summary: "Show version", run: versionCommand},
+		{name: "help", usage: "help [command]", summary: "Show help for a command", run: helpCommand},
+	}
+}
 
-Examples:
-  ralphy "Build a REST API for todos"
-  ralphy "Fix auth bug" --max-iterations 10
-  ralphy "Add tests" --completion-promise "ALL TESTS PASS" --model openai/gpt-5.1
-  ralphy --prompt-file ./prompt.md --max-iterations 5
-  ralphy --status                                        # Check loop status
-  ralphy --add-context "Focus on the auth module first"  # Add hint for next iteration
-
-How it works:
-  1. Sends your prompt to OpenCode
-  2. AI works on the task
-  3. Checks output for completion promise
-  4. If not complete, repeats with same prompt
-  5. AI sees its previous work in files
-  6. Continues until promise detected or max iterations
-
-To stop manually: Ctrl+C
+func main() {
+	os.Exit(dispatch(translateLegacyArgs(hoistLoopFlags(os.Args[1:]))))
+}
 
-Learn more: https://ghuntley.com/ralph/
-`)
+func dispatch(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return exitUsage
 	}
 
-	flag.Parse()
+	switch args[0] {
+	case "-h", "-help", "--help":
+		printUsage()
+		return exitOK
+	case "-v", "-version", "--version":
+		return versionCommand(nil)
+	}
 
-	if *flagHelp {
-		flag.Usage()
-		os.Exit(0)
+	if cmd := findCommand(args[0]); cmd != nil {
+		return cmd.run(args[1:])
 	}
+	return runCommand(args)
+}
 
-	if *flagVersion {
-		fmt.Printf("ralphy %s\n", state.VERSION)
-		os.Exit(0)
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
 	}
+	return nil
+}
+
+// hoistLoopFlags moves --state-dir and --name given before the command name,
+// as in `ralphy --name api status`, to after it, where the command parses
+// them.
+func hoistLoopFlags(args []string) []string {
+	var hoisted []string
+	i := 0
+	for i < len(args) {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || (name != "state-dir" && name != "name") {
+			break
+		}
+		if hasValue || i+1 >= len(args) {
+			hoisted = append(hoisted, args[i])
+			i++
+			continue
+		}
+		hoisted = append(hoisted, args[i], args[i+1])
+		i += 2
+	}
+	if len(hoisted) == 0 || i >= len(args) || findCommand(args[i]) == nil {
+		return args
+	}
+	return append(append([]string{args[i]}, hoisted...), args[i+1:]...)
+}
 
-	if *flagStatus {
-		printStatus()
-		os.Exit(0)
+// translateLegacyArgs maps the flags that used to select an action, such as
+// --status or --add-task, onto the matching subcommand. The other arguments
+// are passed on to it, so `--add-task X --state-dir D` keeps its --state-dir.
+func translateLegacyArgs(args []string) []string {
+	if len(args) > 0 && findCommand(args[0]) != nil {
+		return args
 	}
 
-	if *listTasks {
-		tasks, _, err := state.LoadTasks()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading tasks: %v\n", err)
-			os.Exit(1)
+	for i, arg := range args {
+		if arg == "--" {
+			break
 		}
-		if len(tasks) == 0 {
-			fmt.Println("No tasks found. Use --add-task to create your first task.")
-			os.Exit(0)
+		if !strings.HasPrefix(arg, "-") {
+			continue
 		}
-		fmt.Println("Current tasks:")
-		for i, task := range tasks {
-			statusIcon := "⏸️"
-			if task.Status == "complete" {
-				statusIcon = "✅"
-			} else if task.Status == "in-progress" {
-				statusIcon = "🔄"
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		end := i + 1
+		next := func() []string {
+			if hasValue {
+				return []string{value}
 			}
-			fmt.Printf("%d. %s %s\n", i+1, statusIcon, task.Text)
-			for _, sub := range task.Subtasks {
-				subIcon := "⏸️"
-				if sub.Status == "complete" {
-					subIcon = "✅"
-				} else if sub.Status == "in-progress" {
-					subIcon = "🔄"
-				}
-				fmt.Printf("   %s %s\n", subIcon, sub.Text)
+			if i+1 < len(args) {
+				end = i + 2
+				return []string{args[i+1]}
 			}
+			return nil
 		}
-		os.Exit(0)
-	}
 
-	if *addTask != "" {
-		if err := state.AddTask(*addTask); err != nil {
-			fmt.Fprintf(os.Stderr, "Error adding task: %v\n", err)
-			os.Exit(1)
+		var command []string
+		switch name {
+		case "status":
+			command = []string{"status"}
+		case "list-tasks":
+			command = []string{"tasks", "ls"}
+		case "add-task":
+			command = append([]string{"tasks", "add"}, next()...)
+		case "remove-task":
+			command = append([]string{"tasks", "rm"}, next()...)
+		case "add-context":
+			command = append([]string{"context", "add"}, next()...)
+		case "clear-context":
+			command = []string{"context", "clear"}
+		case "stop", "pause", "unpause", "abort":
+			command = []string{name}
+		default:
+			continue
 		}
-		fmt.Printf("✅ Task added: \"%s\"\n", *addTask)
-		os.Exit(0)
+		command = append(command, args[:i]...)
+		return append(command, args[end:]...)
 	}
+	return args
+}
 
-	if *removeTask > 0 {
-		if err := state.RemoveTask(*removeTask); err != nil {
-			fmt.Fprintf(os.Stderr, "Error removing task: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("✅ Removed task %d and its subtasks\n", *removeTask)
-		os.Exit(0)
+func printUsage() {
+	fmt.Print(`
+Ralphy Wiggum Loop - Iterative AI development with OpenCode
+
+Usage:
+  ralphy "<prompt>" [options]      Same as 'ralphy run'
+  ralphy <command> [arguments]
+
+Commands:
+`)
+	for _, cmd := range commands {
+		fmt.Printf("  %-12s %s\n", cmd.name, cmd.summary)
 	}
+	fmt.Print(`
+Run 'ralphy <command> --help' for the options of a command.
 
-	if *addContext != "" {
-		if *addContext == "" {
-			fmt.Fprintln(os.Stderr, "Error: --add-context requires a text argument")
-			fmt.Fprintln(os.Stderr, "Usage: ralphy --add-context \"Your context or hint here\"")
-			os.Exit(1)
-		}
+Examples:
+  ralphy "Build a REST API for todos"
+  ralphy run "Fix auth bug" --max-iterations 10
+  ralphy run --prompt-file ./prompt.md --verify "go test ./..."
+  ralphy status                                  # Check loop status
+  ralphy context add "Focus on the auth module"  # Add hint for next iteration
+  ralphy tasks add "Write the migration"
+  ralphy runs show 20260102-150405-a1b2          # Inspect a finished run
 
-		if err := state.SaveContext(*addContext); err != nil {
-			fmt.Fprintf(os.Stderr, "Error saving context: %v\n", err)
-			os.Exit(1)
-		}
+The old flags (--status, --add-task, --add-context, --stop, ...) still work.
 
-		fmt.Println("✅ Context added for next iteration")
-		contextPath := ".opencode/ralphy-context.md"
-		fmt.Printf("   File: %s\n", contextPath)
+Learn more: https://ghuntley.com/ralph/
 
-		existingState, _ := state.LoadState()
-		if existingState != nil && existingState.Active {
-			fmt.Printf("   Will be picked up in iteration %d\n", existingState.Iteration+1)
-		} else {
-			fmt.Println("   Will be used when loop starts")
-		}
-		os.Exit(0)
-	}
+`)
+}
 
-	if *flagClearContext {
-		if err := state.ClearContext(); err != nil {
-			fmt.Fprintln(os.Stderr, "Error clearing context:", err)
-			os.Exit(1)
-		}
-		fmt.Println("✅ Context cleared")
-		os.Exit(0)
+func helpCommand(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return exitOK
 	}
-
-	args := flag.Args()
-	var promptParts []string
-	for _, arg := range args {
-		if !strings.HasPrefix(arg, "-") {
-			promptParts = append(promptParts, arg)
-		}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n", args[0])
+		return exitUsage
 	}
+	return cmd.run([]string{"--help"})
+}
 
-	var prompt string
-	var promptSource string
+func versionCommand(args []string) int {
+	fmt.Printf("ralphy %s\n", state.VERSION)
+	return exitOK
+}
 
-	if *promptFile != "" {
-		promptSource = *promptFile
-		content, err := os.ReadFile(*promptFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: Prompt file not found: %s\n", *promptFile)
-			os.Exit(1)
+const loopFlagsDetails = `Loop selection (all commands):
+  --state-dir DIR     Keep state in DIR instead of ./.opencode
+                      (default: $RALPHY_STATE_DIR)
+  --name NAME         Work on the named loop, kept in DIR/loops/NAME
+                      (default: $RALPHY_NAME)
+
+`
+
+// newFlagSet returns the flag set of a subcommand. Its help text starts with
+// the command's usage line, followed by details and the flag defaults.
+func newFlagSet(name string, details string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	defineLoopFlags(fs)
+	fs.Usage = func() {
+		cmd := findCommand(name)
+		fmt.Fprintf(fs.Output(), "\nUsage: ralphy %s\n\n%s\n", cmd.usage, cmd.summary)
+		if details != "" {
+			fmt.Fprint(fs.Output(), details)
+			fmt.Fprint(fs.Output(), loopFlagsDetails)
+			return
 		}
-		prompt = string(content)
-	} else if len(promptParts) == 1 {
-		promptSource = promptParts[0]
-		content, err := os.ReadFile(promptParts[0])
-		if err == nil {
-			prompt = string(content)
-		} else {
-			prompt = strings.Join(promptParts, " ")
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(fs.Output(), "\nOptions:")
+			fs.PrintDefaults()
 		}
-	} else {
-		prompt = strings.Join(promptParts, " ")
+		fmt.Fprintln(fs.Output())
 	}
+	return fs
+}
 
-	if prompt == "" {
-		fmt.Fprintln(os.Stderr, "Error: No prompt provided")
-		fmt.Fprintln(os.Stderr, "Usage: ralphy \"Your task description\" [options]")
-		fmt.Fprintln(os.Stderr, "Run 'ralphy --help' for more information")
-		os.Exit(1)
+// parseArgs parses flags anywhere among args, so options may follow
+// positional arguments. Everything after "--" is positional, which is how a
+// prompt word starting with "-" gets through. ok is false when the command
+// should exit with code instead of running.
+func parseArgs(fs *flag.FlagSet, args []string) (positional []string, code int, ok bool) {
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, exitOK, false
+			}
+			return nil, exitUsage, false
+		}
+		rest := fs.Args()
+		consumed := len(args) - len(rest)
+		if consumed > 0 && args[consumed-1] == "--" {
+			if err := selectLoop(fs); err != nil {
+				return nil, usageError(fs, "%v", err), false
+			}
+			return append(positional, rest...), exitOK, true
+		}
+		if len(rest) == 0 {
+			if err := selectLoop(fs); err != nil {
+				return nil, usageError(fs, "%v", err), false
+			}
+			return positional, exitOK, true
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
 	}
+}
+
+// defineLoopFlags adds the options that select which loop a command works
+// on; every command accepts them.
+func defineLoopFlags(fs *flag.FlagSet) {
+	fs.String("state-dir", "", "State directory (default: $RALPHY_STATE_DIR or ./.opencode)")
+	fs.String("name", "", "Name of the loop to work on (default: $RALPHY_NAME)")
+}
 
-	timeout, err := time.ParseDuration(*timeoutStr)
-	if err != nil {
-		if *timeoutStr == "0" {
-			timeout = 0
-		} else {
-			fmt.Fprintf(os.Stderr, "Error: Invalid timeout duration: %s\n", *timeoutStr)
-			os.Exit(1)
+// selectLoop points the state package at the loop chosen by --state-dir and
+// --name, or their RALPHY_* variables.
+func selectLoop(fs *flag.FlagSet) error {
+	lookup := func(name string) string {
+		if value := fs.Lookup(name).Value.String(); value != "" {
+			return value
 		}
+		return os.Getenv(config.EnvName(name))
 	}
-
-	opts := &RunOptions{
-		Prompt:              prompt,
-		PromptSource:        promptSource,
-		MaxIterations:       *maxIterations,
-		CompletionPromise:   *completionPromise,
-		TaskPromise:         *taskPromise,
-		Model:               *model,
-		StreamOutput:        !*noStream,
-		VerboseTools:        *verboseTools,
-		DisablePlugins:      *noPlugins,
-		AutoCommit:          !*noCommit,
-		AllowAllPermissions: *allowAll,
-		Verbose:             *verbose,
-		Timeout:             timeout,
+	if err := state.SetStateDir(lookup("state-dir")); err != nil {
+		return err
 	}
+	return state.SetLoopName(lookup("name"))
+}
+
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
+	fs.Usage()
+	return exitUsage
+}
+
+// stringList collects the values of a flag that may be given more than once.
+type stringList []string
 
-	if err := loop.RunLoop(&loop.LoopOptions{
-		Prompt:              opts.Prompt,
-		PromptSource:        opts.PromptSource,
-		MaxIterations:       opts.MaxIterations,
-		CompletionPromise:   opts.CompletionPromise,
-		TaskPromise:         opts.TaskPromise,
-		Model:               opts.Model,
-		StreamOutput:        opts.StreamOutput,
-		VerboseTools:        opts.VerboseTools || opts.Verbose,
-		DisablePlugins:      opts.DisablePlugins,
-		AutoCommit:          opts.AutoCommit,
-		AllowAllPermissions: opts.AllowAllPermissions,
-		Verbose:             opts.Verbose,
-		Timeout:             opts.Timeout,
-	}); err != nil {
-		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
-		state.ClearState()
-		os.Exit(1)
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
 	}
+	return strings.Join(*l, ", ")
 }
 
-type RunOptions struct {
-	Prompt              string
-	PromptSource        string
-	MaxIterations       int
-	CompletionPromise   string
-	TaskPromise         string
-	Model               string
-	StreamOutput        bool
-	VerboseTools        bool
-	DisablePlugins      bool
-	AutoCommit          bool
-	AllowAllPermissions bool
-	Verbose             bool
-	Timeout             time.Duration
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTranslateLegacyArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"--status"}, []string{"status"}},
+		{[]string{"--status", "--tasks"}, []string{"status", "--tasks"}},
+		{[]string{"--list-tasks"}, []string{"tasks", "ls"}},
+		{[]string{"--add-task", "Write docs", "--state-dir", "d"}, []string{"tasks", "add", "Write docs", "--state-dir", "d"}},
+		{[]string{"--state-dir", "d", "--add-task=Write docs"}, []string{"tasks", "add", "Write docs", "--state-dir", "d"}},
+		{[]string{"--remove-task", "2"}, []string{"tasks", "rm", "2"}},
+		{[]string{"--add-context", "hint", "--name", "api"}, []string{"context", "add", "hint", "--name", "api"}},
+		{[]string{"--add-context"}, []string{"context", "add"}},
+		{[]string{"-clear-context"}, []string{"context", "clear"}},
+		{[]string{"--name", "api", "--stop"}, []string{"stop", "--name", "api"}},
+		{[]string{"Fix it", "--max-iterations", "3"}, []string{"Fix it", "--max-iterations", "3"}},
+		{[]string{"run", "--status"}, []string{"run", "--status"}},
+		{[]string{"--", "--status"}, []string{"--", "--status"}},
+	}
+	for _, tt := range tests {
+		if got := translateLegacyArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("translateLegacyArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/wltechblog/ralphy/internal/pricing"
+)
+
+type pricesReport struct {
+	SchemaVersion int                 `json:"schemaVersion"`
+	Prices        []pricesReportEntry `json:"prices"`
+}
+
+type pricesReportEntry struct {
+	Model string `json:"model"`
+	pricing.Price
+	Source string `json:"source"`
+}
+
+func pricesCommand(args []string) int {
+	fs := newFlagSet("prices", "")
+	jsonOutput := fs.Bool("json", false, "Print the price table as JSON")
+	positional, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 0 {
+		return usageError(fs, "unexpected argument %q", positional[0])
+	}
+
+	prices, err := pricing.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading prices: %v\n", err)
+		return exitError
+	}
+
+	if *jsonOutput {
+		report := &pricesReport{SchemaVersion: reportSchemaVersion, Prices: []pricesReportEntry{}}
+		for _, model := range prices.Models() {
+			price := prices[model]
+			report.Prices = append(report.Prices, pricesReportEntry{Model: model, Price: price, Source: price.Source})
+		}
+		return printJSONReport(report)
+	}
+
+	fmt.Println("💲 MODEL PRICES (USD per million tokens)")
+	fmt.Println("")
+	fmt.Printf("   %-24s  %8s  %8s  %10s  %11s  %s\n", "MODEL", "INPUT", "OUTPUT", "CACHE READ", "CACHE WRITE", "SOURCE")
+	for _, model := range prices.Models() {
+		p := prices[model]
+		fmt.Printf("   %-24s  %8s  %8s  %10s  %11s  %s\n", model, formatPrice(p.Input), formatPrice(p.Output), formatPrice(p.CacheRead), formatPrice(p.CacheWrite), p.Source)
+	}
+	fmt.Println("")
+	userPath, _ := pricing.UserFilePath()
+	fmt.Printf("Override or add models in %s or ./%s, e.g.\n", userPath, pricing.ProjectFileName)
+	fmt.Println(`  {"anthropic/claude-sonnet-4": {"input": 3, "output": 15, "cacheRead": 0.3, "cacheWrite": 3.75}}`)
+	return exitOK
+}
+
+func formatPrice(usd float64) string {
+	return strconv.FormatFloat(usd, 'f', -1, 64)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+// reportSchemaVersion is bumped whenever a field of the JSON output is
+// renamed, removed or changes meaning. New fields may appear without a bump.
+const reportSchemaVersion = 2
+
+const watchPollInterval = 1 * time.Second
+
+type statusReport struct {
+	SchemaVersion  int                      `json:"schemaVersion"`
+	GeneratedAt    string                   `json:"generatedAt"`
+	Loop           *loopReport              `json:"loop"`
+	Control        *state.ControlRequest    `json:"control"`
+	Tasks          []state.Task             `json:"tasks"`
+	PendingContext string                   `json:"pendingContext"`
+	Progress       string                   `json:"progress"`
+	Stats          statusStats              `json:"stats"`
+	Struggle       state.StruggleIndicators `json:"struggleIndicators"`
+	History        []state.IterationHistory `json:"history"`
+	Problems       []string                 `json:"problems,omitempty"`
+}
+
+type loopReport struct {
+	*state.RalphState
+	Owner string `json:"owner"`
+	// WallClockMs is the time since the loop started, unlike the state's
+	// ElapsedMs including the time it sat stopped between sessions.
+	WallClockMs    int64 `json:"wallClockMs"`
+	HeartbeatAgeMs int64 `json:"heartbeatAgeMs,omitempty"`
+}
+
+type statusStats struct {
+	Iterations          int              `json:"iterations"`
+	TotalDurationMs     int64            `json:"totalDurationMs"`
+	TotalTokens         state.TokenUsage `json:"totalTokens"`
+	TotalCostUSD        float64          `json:"totalCostUsd"`
+	AverageIterationMs  int64            `json:"averageIterationMs"`
+	RemainingIterations *int             `json:"remainingIterations"`
+	EtaMs               *int64           `json:"etaMs"`
+	TasksTotal          int              `json:"tasksTotal"`
+	TasksComplete       int              `json:"tasksComplete"`
+	CompletionRejected  int              `json:"completionRejected"`
+	TimedOut            int              `json:"timedOut"`
+	AgentFailures       int              `json:"agentFailures"`
+}
+
+type loopsReport struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	GeneratedAt   string           `json:"generatedAt"`
+	Loops         []state.LoopInfo `json:"loops"`
+	Problems      []string         `json:"problems,omitempty"`
+}
+
+type historyReport struct {
+	SchemaVersion int `json:"schemaVersion"`
+	*state.RalphHistory
+	Problems []string `json:"problems,omitempty"`
+}
+
+func buildStatusReport() *statusReport {
+	report := &statusReport{
+		SchemaVersion: reportSchemaVersion,
+		GeneratedAt:   time.Now().Format(time.RFC3339),
+		Tasks:         []state.Task{},
+		History:       []state.IterationHistory{},
+	}
+
+	s, err := state.LoadState()
+	if err == nil {
+		loop := &loopReport{RalphState: s, Owner: state.CheckOwner(s)}
+		if startedAt, err := time.Parse(time.RFC3339, s.StartedAt); err == nil {
+			loop.WallClockMs = time.Since(startedAt).Milliseconds()
+		}
+		if s.HeartbeatAt != "" {
+			loop.HeartbeatAgeMs = state.HeartbeatAge(s).Milliseconds()
+		}
+		report.Loop = loop
+	} else if !errors.Is(err, state.ErrStateNotFound) {
+		report.Problems = append(report.Problems, err.Error())
+	}
+
+	report.Control, _ = state.LoadControl()
+	if tasks, _, err := state.LoadTasks(); err == nil && tasks != nil {
+		report.Tasks = tasks
+	}
+	report.PendingContext, _ = state.LoadContext()
+	if data, err := os.ReadFile("PROGRESS.md"); err == nil {
+		report.Progress = string(data)
+	}
+
+	h, err := state.LoadHistory()
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+		h = &state.RalphHistory{}
+	}
+	if h.Iterations != nil {
+		report.History = h.Iterations
+	}
+	report.Struggle = h.StruggleIndicators
+
+	stats := &report.Stats
+	stats.Iterations = len(h.Iterations)
+	stats.TotalDurationMs = h.TotalDurationMs
+	stats.TotalTokens = h.TotalTokens
+	stats.TotalCostUSD = h.TotalCostUSD
+	stats.AgentFailures = len(h.AgentFailures)
+	var iterationMs int64
+	for _, iter := range h.Iterations {
+		iterationMs += iter.DurationMs
+		if iter.CompletionRejected {
+			stats.CompletionRejected++
+		}
+		if iter.TimedOut {
+			stats.TimedOut++
+		}
+	}
+	if len(h.Iterations) > 0 {
+		stats.AverageIterationMs = iterationMs / int64(len(h.Iterations))
+	}
+	if s != nil && s.Active && s.MaxIterations > 0 {
+		remaining := s.MaxIterations - s.Iteration + 1
+		if remaining < 0 {
+			remaining = 0
+		}
+		eta := stats.AverageIterationMs * int64(remaining)
+		stats.RemainingIterations = &remaining
+		stats.EtaMs = &eta
+	}
+	stats.TasksTotal = len(report.Tasks)
+	for _, task := range report.Tasks {
+		if task.Status == "complete" {
+			stats.TasksComplete++
+		}
+	}
+
+	return report
+}
+
+func buildLoopsReport() *loopsReport {
+	report := &loopsReport{
+		SchemaVersion: reportSchemaVersion,
+		GeneratedAt:   time.Now().Format(time.RFC3339),
+		Loops:         []state.LoopInfo{},
+	}
+	loops, err := state.ListLoops()
+	if err != nil {
+		report.Problems = []string{err.Error()}
+	}
+	if loops != nil {
+		report.Loops = loops
+	}
+	return report
+}
+
+func buildHistoryReport(limit int, fromEvents bool) *historyReport {
+	report := &historyReport{SchemaVersion: reportSchemaVersion}
+	h, err := loadHistory(fromEvents)
+	if err != nil {
+		if !errors.Is(err, state.ErrStateNotFound) {
+			report.Problems = []string{err.Error()}
+		}
+		h = &state.RalphHistory{}
+	}
+	if h.Iterations == nil {
+		h.Iterations = []state.IterationHistory{}
+	}
+	if limit > 0 && len(h.Iterations) > limit {
+		h.Iterations = h.Iterations[len(h.Iterations)-limit:]
+	}
+	report.RalphHistory = h
+	return report
+}
+
+func printJSON(v interface{}, compact bool) error {
+	var data []byte
+	var err error
+	if compact {
+		data, err = json.Marshal(v)
+	} else {
+		data, err = json.MarshalIndent(v, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// stateFingerprint summarises the size and modification time of every file
+// the status view is built from, so watchers can tell when to redraw.
+func stateFingerprint() string {
+	var paths []string
+	if stateDir, err := state.GetStateDir(); err == nil {
+		entries, _ := os.ReadDir(stateDir)
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				paths = append(paths, filepath.Join(stateDir, entry.Name()))
+			}
+		}
+	}
+	paths = append(paths, "PROGRESS.md")
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String()
+}
+
+// watch calls render now and again whenever the state files change, until
+// the process is interrupted.
+func watch(render func()) {
+	last := stateFingerprint()
+	render()
+	for {
+		time.Sleep(watchPollInterval)
+		current := stateFingerprint()
+		if current == last {
+			continue
+		}
+		last = current
+		render()
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wltechblog/ralphy/internal/agent"
+	"github.com/wltechblog/ralphy/internal/git"
+	"github.com/wltechblog/ralphy/internal/loop"
+	"github.com/wltechblog/ralphy/internal/pricing"
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+const runDetails = `
+Arguments:
+  prompt              Task description for the AI to work on, or a file to read
+                      it from. Put it after "--" if it starts with "-".
+
+Options:
+  --max-iterations N  Maximum iterations before stopping (default: unlimited)
+  --completion-promise TEXT  Phrase that signals completion (default: COMPLETE)
+  --task-promise TEXT Phrase that signals task completion (default: READY_FOR_NEXT_TASK)
+  --model MODEL       Model to use (e.g., anthropic/claude-sonnet)
+  --model-chain LIST  Comma-separated models, cheapest first: start on the first,
+                      move up when struggling or on agent failures, and back
+                      down once progress resumes
+  --escalate-after N  Move up the chain after N iterations without file changes,
+                      N very short ones or N with the same error (default: 3)
+  --deescalate-after N  Move back down after N iterations that changed files
+                      (default: 2, 0 to stay on the stronger model)
+  --agent NAME        Agent backend to use (default: opencode)
+  --agent-command CMD Command template for --agent generic, e.g.
+                      "mytool --model {{model}} -p {{prompt_file}}"
+  --prompt-via MODE   Deliver the prompt to a generic agent via argv, stdin or file
+  --tool-pattern RE   Regex for tool lines (first capture group is the tool name)
+  --json-events       Decode OpenCode's JSON event stream instead of scraping text
+  --server-url URL    Attach --agent opencode-server to a running 'opencode serve'
+                      (default: start one for the duration of the loop)
+  --reuse-session     Keep one opencode-server session across iterations
+  --prompt-file, --file, -f  Read prompt content from a file
+  --no-stream         Buffer OpenCode output and print at the end
+  --verbose-tools     Print every tool line (disable compact tool summary)
+  --no-plugins        Disable non-auth OpenCode plugins for this run
+  --no-commit         Don't auto-commit after each iteration
+  --branch NAME       Commit iterations to branch NAME, created from the current
+                      branch; "auto" names it ralphy/<run-id>
+  --merge MODE        On completion, bring the branch back into the branch the
+                      loop started from: squash or merge (implies --branch auto)
+  --allow-all         Auto-approve all tool permissions (for non-interactive use)
+  --verbose           Show more verbose output from OpenCode
+  --timeout DUR       Timeout if no activity (default: 1h, 0 to disable)
+  --iteration-timeout DUR  Stop the agent once an iteration has run this long,
+                      busy or not; its work is still checked and committed
+                      (default: 0, no limit)
+  --retry-delay DUR   Wait this long before retrying a failed agent run, doubling
+                      on every failure in a row (default: 5s)
+  --retry-max-delay DUR  Longest wait between retries (default: 5m)
+  --max-failures N    Pause the loop after N agent failures in a row
+                      (default: 5, 0 to keep retrying)
+  --max-duration DUR  Stop once the loop has run this long, e.g. 4h
+  --max-cost USD      Stop once the run has cost this much, e.g. 5.00
+  --max-tokens N      Stop once the run has used this many tokens, cache reads
+                      and writes included
+  --budget-warn PCTS  Warn at these percentages of a budget (default: 80),
+                      comma-separated, e.g. 50,80,95
+  --hard-budget       Interrupt the running iteration when a budget runs out
+                      (default: stop before the next iteration)
+  --check CMD         Run CMD after every iteration and show its result at the
+                      top of the next prompt; repeatable, e.g. --check "go vet ./..."
+  --verify CMD        Only accept the completion promise if CMD exits zero;
+                      repeatable, e.g. --verify "go test ./..."
+  --no-transcripts    Don't save each iteration's prompt and output under
+                      .opencode/ralph-transcripts (see 'ralphy transcript')
+  --compress-transcripts  Gzip the saved transcripts
+  --profile NAME      Apply a named profile from the config files
+                      (default: $RALPHY_PROFILE)
+  --resume            Continue an interrupted loop from its saved iteration,
+                      prompt, model and promises
+  --takeover          Replace a stale loop without asking; a loop on another
+                      host is stale once it has sent no heartbeat for 2m
+
+Config:
+  Options default to values from .ralphy.json or ralphy.toml in the project
+  and from $XDG_CONFIG_HOME/ralphy/config.json or config.toml, keyed by flag
+  name, and from RALPHY_<FLAG> environment variables (e.g.
+  RALPHY_MAX_ITERATIONS).
+  Precedence: flags > env > project > user. See 'ralphy config show'.
+
+How it works:
+  1. Sends your prompt to OpenCode
+  2. AI works on the task
+  3. Checks output for completion promise
+  4. If not complete, repeats with same prompt
+  5. AI sees its previous work in files
+  6. Continues until promise detected or max iterations
+
+To stop manually: Ctrl+C (or SIGTERM/SIGHUP); state is kept for --resume
+
+Exit codes: 0 completed, 1 error, 2 max iterations, 3 stopped,
+            4 aborted, 5 budget exceeded, 64 invalid usage,
+            128+N interrupted by signal N
+
+`
+
+type runFlags struct {
+	maxIterations       *int
+	completionPromise   *string
+	taskPromise         *string
+	model               *string
+	modelChain          *string
+	escalateAfter       *int
+	deescalateAfter     *int
+	agentName           *string
+	agentCommand        *string
+	promptVia           *string
+	toolPattern         *string
+	jsonEvents          *bool
+	serverURL           *string
+	reuseSession        *bool
+	promptFile          *string
+	noStream            *bool
+	verboseTools        *bool
+	noPlugins           *bool
+	noCommit            *bool
+	branch              *string
+	merge               *string
+	allowAll            *bool
+	verbose             *bool
+	timeout             *string
+	iterationTimeout    *string
+	retryDelay          *string
+	retryMaxDelay       *string
+	maxFailures         *int
+	maxDuration         *string
+	maxCost             *float64
+	maxTokens           *int64
+	budgetWarn          *string
+	hardBudget          *bool
+	profile             *string
+	resume              *bool
+	takeover            *bool
+	verify              stringList
+	checks              stringList
+	noTranscripts       *bool
+	compressTranscripts *bool
+}
+
+func defineRunFlags(fs *flag.FlagSet) *runFlags {
+	f := &runFlags{}
+	f.maxIterations = fs.Int("max-iterations", 0, "Maximum iterations before stopping (default: unlimited)")
+	f.completionPromise = fs.String("completion-promise", "COMPLETE", "Phrase that signals completion")
+	f.taskPromise = fs.String("task-promise", "READY_FOR_NEXT_TASK", "Phrase that signals task completion")
+	f.model = fs.String("model", "", "Model to use (e.g., anthropic/claude-sonnet)")
+	f.modelChain = fs.String("model-chain", "", "Comma-separated models to escalate through, cheapest first")
+	f.escalateAfter = fs.Int("escalate-after", loop.DefaultEscalateAfter, "Move up the model chain after N struggling iterations")
+	f.deescalateAfter = fs.Int("deescalate-after", loop.DefaultDeescalateAfter, "Move down the model chain after N iterations with progress (0 to never)")
+	f.agentName = fs.String("agent", "", "Agent backend to run each iteration (default: opencode)")
+	f.agentCommand = fs.String("agent-command", "", "Command template for the generic agent backend")
+	f.promptVia = fs.String("prompt-via", "", "How the generic agent receives the prompt: argv, stdin or file")
+	f.toolPattern = fs.String("tool-pattern", "", "Regex that detects tool lines in agent output")
+	f.jsonEvents = fs.Bool("json-events", false, "Run OpenCode with --format json and decode its event stream")
+	f.serverURL = fs.String("server-url", "", "Attach the opencode-server agent to a running `opencode serve`")
+	f.reuseSession = fs.Bool("reuse-session", false, "Keep one opencode-server session across iterations")
+	f.promptFile = fs.String("prompt-file", "", "Read prompt content from a file")
+	fs.StringVar(f.promptFile, "file", "", "Alias for --prompt-file")
+	fs.StringVar(f.promptFile, "f", "", "Alias for --prompt-file")
+	f.noStream = fs.Bool("no-stream", false, "Buffer OpenCode output and print at the end")
+	f.verboseTools = fs.Bool("verbose-tools", false, "Print every tool line")
+	f.noPlugins = fs.Bool("no-plugins", false, "Disable non-auth OpenCode plugins")
+	f.noCommit = fs.Bool("no-commit", false, "Don't auto-commit after each iteration")
+	f.branch = fs.String("branch", "", "Commit iterations to this branch (\"auto\" for ralphy/<run-id>)")
+	f.merge = fs.String("merge", "", "Bring the branch back into the base branch on completion: squash or merge")
+	f.allowAll = fs.Bool("allow-all", false, "Auto-approve all tool permissions")
+	f.verbose = fs.Bool("verbose", false, "Show more verbose output from OpenCode")
+	f.timeout = fs.String("timeout", "1h", "Timeout if no activity (e.g. 1h, 30m, 0 to disable)")
+	f.iterationTimeout = fs.String("iteration-timeout", "0", "Stop the agent once an iteration has run this long (e.g. 30m, 0 to disable)")
+	f.retryDelay = fs.String("retry-delay", "5s", "Wait before retrying a failed agent run, doubled on every failure in a row")
+	f.retryMaxDelay = fs.String("retry-max-delay", "5m", "Longest wait between retries of a failed agent run")
+	f.maxFailures = fs.Int("max-failures", loop.DefaultMaxFailures, "Pause the loop after N agent failures in a row (0 to keep retrying)")
+	f.maxDuration = fs.String("max-duration", "", "Stop once the loop has run this long (e.g. 4h)")
+	f.maxCost = fs.Float64("max-cost", 0, "Stop once the run has cost this many US dollars")
+	f.maxTokens = fs.Int64("max-tokens", 0, "Stop once the run has used this many tokens, cache reads and writes included")
+	f.budgetWarn = fs.String("budget-warn", "80", "Percentages of a budget to warn at, comma-separated")
+	f.hardBudget = fs.Bool("hard-budget", false, "Interrupt the running iteration when a budget runs out")
+	f.noTranscripts = fs.Bool("no-transcripts", false, "Don't save the prompt and output of each iteration")
+	f.compressTranscripts = fs.Bool("compress-transcripts", false, "Gzip the saved iteration transcripts")
+	f.profile = fs.String("profile", "", "Config profile to apply (default: $RALPHY_PROFILE)")
+	f.resume = fs.Bool("resume", false, "Resume an interrupted loop from its saved state")
+	f.takeover = fs.Bool("takeover", false, "Take over a stale loop without asking")
+	fs.Var(&f.checks, "check", "Command to run after every iteration, reported in the next prompt (repeatable)")
+	fs.Var(&f.verify, "verify", "Command that must pass before a completion promise is accepted (repeatable)")
+	return f
+}
+
+func runCommand(args []string) int {
+	fs := newFlagSet("run", runDetails+fmt.Sprintf("Available agents: %s\n\n", strings.Join(agent.Names(), ", ")))
+	flags := defineRunFlags(fs)
+	promptParts, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+
+	if _, err := applyConfig(fs, *flags.profile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return exitError
+	}
+	prices, err := pricing.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading prices: %v\n", err)
+		return exitError
+	}
+
+	var prompt string
+	var promptSource string
+
+	if *flags.promptFile != "" {
+		promptSource = *flags.promptFile
+		content, err := os.ReadFile(*flags.promptFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Prompt file not found: %s\n", *flags.promptFile)
+			return exitError
+		}
+		prompt = string(content)
+	} else if len(promptParts) == 1 {
+		promptSource = promptParts[0]
+		content, err := os.ReadFile(promptParts[0])
+		if err == nil {
+			prompt = string(content)
+		} else {
+			prompt = strings.Join(promptParts, " ")
+		}
+	} else {
+		prompt = strings.Join(promptParts, " ")
+	}
+
+	if prompt == "" && !*flags.resume {
+		fmt.Fprintln(os.Stderr, "Error: No prompt provided")
+		fmt.Fprintln(os.Stderr, "Usage: ralphy \"Your task description\" [options]")
+		fmt.Fprintln(os.Stderr, "Run 'ralphy run --help' for more information")
+		return exitUsage
+	}
+
+	opts, err := parseRunOptions(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	opts.Prompt = prompt
+	opts.PromptSource = promptSource
+
+	result, err := loop.RunLoop(opts.loopOptions(prices))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		// Leave an active loop's state, and a state file only doctor
+		// should touch, where they are.
+		keep := errors.Is(err, loop.ErrLoopActive) || errors.Is(err, state.ErrCorruptState) || errors.Is(err, state.ErrSchemaTooNew)
+		if !keep && !opts.Resume {
+			state.ClearState()
+		}
+		return exitError
+	}
+	return result.ExitCode()
+}
+
+type RunOptions struct {
+	Prompt              string
+	PromptSource        string
+	MaxIterations       int
+	CompletionPromise   string
+	TaskPromise         string
+	Model               string
+	ModelChain          []string
+	EscalateAfter       int
+	DeescalateAfter     int
+	Agent               string
+	AgentCommand        string
+	PromptVia           string
+	ToolPattern         string
+	JSONEvents          bool
+	ServerURL           string
+	ReuseSession        bool
+	StreamOutput        bool
+	VerboseTools        bool
+	DisablePlugins      bool
+	AutoCommit          bool
+	Branch              string
+	Merge               string
+	AllowAllPermissions bool
+	Verbose             bool
+	Timeout             time.Duration
+	IterationTimeout    time.Duration
+	RetryDelay          time.Duration
+	RetryMaxDelay       time.Duration
+	MaxFailures         int
+	Budget              state.Budget
+	Verify              []string
+	Checks              []string
+	Transcripts         bool
+	CompressTranscripts bool
+	Resume              bool
+	Takeover            bool
+}
+
+// parseRunOptions checks the loop options in flags and converts them. The
+// prompt is left for the caller.
+func parseRunOptions(flags *runFlags) (*RunOptions, error) {
+	timeout, err := time.ParseDuration(*flags.timeout)
+	if err != nil {
+		if *flags.timeout == "0" {
+			timeout = 0
+		} else {
+			return nil, fmt.Errorf("invalid timeout duration: %s", *flags.timeout)
+		}
+	}
+
+	iterationTimeout, err := time.ParseDuration(*flags.iterationTimeout)
+	if err != nil || iterationTimeout < 0 {
+		if *flags.iterationTimeout != "0" {
+			return nil, fmt.Errorf("invalid iteration timeout duration: %s", *flags.iterationTimeout)
+		}
+		iterationTimeout = 0
+	}
+
+	retryDelay, err := time.ParseDuration(*flags.retryDelay)
+	if err != nil || retryDelay <= 0 {
+		return nil, fmt.Errorf("invalid retry delay: %s", *flags.retryDelay)
+	}
+	retryMaxDelay, err := time.ParseDuration(*flags.retryMaxDelay)
+	if err != nil || retryMaxDelay < retryDelay {
+		return nil, fmt.Errorf("invalid retry max delay: %s (must be at least --retry-delay)", *flags.retryMaxDelay)
+	}
+	if *flags.maxFailures < 0 {
+		return nil, fmt.Errorf("invalid --max-failures: %d", *flags.maxFailures)
+	}
+
+	var modelChain []string
+	for _, model := range strings.Split(*flags.modelChain, ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			modelChain = append(modelChain, model)
+		}
+	}
+	if *flags.escalateAfter < 0 || *flags.deescalateAfter < 0 {
+		return nil, errors.New("--escalate-after and --deescalate-after must not be negative")
+	}
+
+	branch := strings.TrimSpace(*flags.branch)
+	switch *flags.merge {
+	case "", git.MergeSquash, git.MergeCommit:
+	default:
+		return nil, fmt.Errorf("invalid --merge: %s (use squash or merge)", *flags.merge)
+	}
+	if *flags.merge != "" && *flags.noCommit {
+		return nil, errors.New("--merge needs the iteration commits that --no-commit turns off")
+	}
+	if *flags.merge != "" && branch == "" {
+		branch = loop.AutoBranch
+	}
+
+	budget, err := parseBudget(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RunOptions{
+		MaxIterations:       *flags.maxIterations,
+		CompletionPromise:   *flags.completionPromise,
+		TaskPromise:         *flags.taskPromise,
+		Model:               *flags.model,
+		ModelChain:          modelChain,
+		EscalateAfter:       *flags.escalateAfter,
+		DeescalateAfter:     *flags.deescalateAfter,
+		Agent:               *flags.agentName,
+		AgentCommand:        *flags.agentCommand,
+		PromptVia:           *flags.promptVia,
+		ToolPattern:         *flags.toolPattern,
+		JSONEvents:          *flags.jsonEvents,
+		ServerURL:           *flags.serverURL,
+		ReuseSession:        *flags.reuseSession,
+		StreamOutput:        !*flags.noStream,
+		VerboseTools:        *flags.verboseTools,
+		DisablePlugins:      *flags.noPlugins,
+		AutoCommit:          !*flags.noCommit,
+		Branch:              branch,
+		Merge:               *flags.merge,
+		AllowAllPermissions: *flags.allowAll,
+		Verbose:             *flags.verbose,
+		Timeout:             timeout,
+		IterationTimeout:    iterationTimeout,
+		RetryDelay:          retryDelay,
+		RetryMaxDelay:       retryMaxDelay,
+		MaxFailures:         *flags.maxFailures,
+		Budget:              budget,
+		Verify:              flags.verify,
+		Checks:              flags.checks,
+		Transcripts:         !*flags.noTranscripts,
+		CompressTranscripts: *flags.compressTranscripts,
+		Resume:              *flags.resume,
+		Takeover:            *flags.takeover,
+	}, nil
+}
+
+// loopOptions converts the options for loop.RunLoop.
+func (opts *RunOptions) loopOptions(prices pricing.Table) *loop.LoopOptions {
+	return &loop.LoopOptions{
+		Prompt:              opts.Prompt,
+		PromptSource:        opts.PromptSource,
+		MaxIterations:       opts.MaxIterations,
+		CompletionPromise:   opts.CompletionPromise,
+		TaskPromise:         opts.TaskPromise,
+		Model:               opts.Model,
+		ModelChain:          opts.ModelChain,
+		EscalateAfter:       opts.EscalateAfter,
+		DeescalateAfter:     opts.DeescalateAfter,
+		Agent:               opts.Agent,
+		AgentCommand:        opts.AgentCommand,
+		PromptVia:           opts.PromptVia,
+		ToolPattern:         opts.ToolPattern,
+		JSONEvents:          opts.JSONEvents,
+		ServerURL:           opts.ServerURL,
+		ReuseSession:        opts.ReuseSession,
+		StreamOutput:        opts.StreamOutput,
+		VerboseTools:        opts.VerboseTools || opts.Verbose,
+		DisablePlugins:      opts.DisablePlugins,
+		AutoCommit:          opts.AutoCommit,
+		Branch:              opts.Branch,
+		Merge:               opts.Merge,
+		AllowAllPermissions: opts.AllowAllPermissions,
+		Verbose:             opts.Verbose,
+		Timeout:             opts.Timeout,
+		IterationTimeout:    opts.IterationTimeout,
+		RetryDelay:          opts.RetryDelay,
+		RetryMaxDelay:       opts.RetryMaxDelay,
+		MaxFailures:         opts.MaxFailures,
+		Budget:              opts.Budget,
+		Verify:              opts.Verify,
+		Checks:              opts.Checks,
+		Transcripts:         opts.Transcripts,
+		CompressTranscripts: opts.CompressTranscripts,
+		Resume:              opts.Resume,
+		Takeover:            opts.Takeover,
+		Prices:              prices,
+	}
+}
+
+// parseBudget reads the budget flags.
+func parseBudget(flags *runFlags) (state.Budget, error) {
+	budget := state.Budget{
+		MaxCostUSD: *flags.maxCost,
+		MaxTokens:  *flags.maxTokens,
+		Hard:       *flags.hardBudget,
+	}
+	if *flags.maxDuration != "" && *flags.maxDuration != "0" {
+		d, err := time.ParseDuration(*flags.maxDuration)
+		if err != nil || d < 0 {
+			return budget, fmt.Errorf("invalid --max-duration: %s", *flags.maxDuration)
+		}
+		budget.MaxDurationMs = d.Milliseconds()
+	}
+	if budget.MaxCostUSD < 0 {
+		return budget, fmt.Errorf("invalid --max-cost: %v", budget.MaxCostUSD)
+	}
+	if budget.MaxTokens < 0 {
+		return budget, fmt.Errorf("invalid --max-tokens: %d", budget.MaxTokens)
+	}
+	for _, field := range strings.Split(*flags.budgetWarn, ",") {
+		field = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(field), "%"))
+		if field == "" {
+			continue
+		}
+		percent, err := strconv.Atoi(field)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return budget, fmt.Errorf("invalid --budget-warn percentage: %s", field)
+		}
+		budget.WarnAt = append(budget.WarnAt, percent)
+	}
+	return budget, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+func TestParseBudget(t *testing.T) {
+	tests := []struct {
+		args []string
+		want state.Budget
+		err  string
+	}{
+		{args: nil, want: state.Budget{WarnAt: []int{80}}},
+		{
+			args: []string{"--max-duration", "1h30m", "--max-cost", "5", "--max-tokens", "2000000", "--budget-warn", "50, 80%,95", "--hard-budget"},
+			want: state.Budget{MaxDurationMs: 90 * 60 * 1000, MaxCostUSD: 5, MaxTokens: 2000000, WarnAt: []int{50, 80, 95}, Hard: true},
+		},
+		{args: []string{"--max-duration", "0", "--budget-warn", ""}, want: state.Budget{}},
+		{args: []string{"--max-duration", "4"}, err: "invalid --max-duration: 4"},
+		{args: []string{"--max-duration", "-1h"}, err: "invalid --max-duration: -1h"},
+		{args: []string{"--max-cost", "-1"}, err: "invalid --max-cost: -1"},
+		{args: []string{"--max-tokens", "-5"}, err: "invalid --max-tokens: -5"},
+		{args: []string{"--budget-warn", "100"}, err: "invalid --budget-warn percentage: 100"},
+		{args: []string{"--budget-warn", "half"}, err: "invalid --budget-warn percentage: half"},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags := defineRunFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseBudget(flags)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%v: err = %v, want %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/wltechblog/ralphy/internal/state"
+	"github.com/wltechblog/ralphy/internal/tools"
+)
+
+type runsReport struct {
+	SchemaVersion int                `json:"schemaVersion"`
+	Runs          []state.RunOutcome `json:"runs"`
+}
+
+type runReport struct {
+	SchemaVersion int `json:"schemaVersion"`
+	*state.ArchivedRun
+}
+
+func runsCommand(args []string) int {
+	fs := newFlagSet("runs", "")
+	jsonOutput := fs.Bool("json", false, "Print the runs as JSON")
+	positional, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) == 0 {
+		positional = []string{"ls"}
+	}
+
+	switch positional[0] {
+	case "ls":
+		if len(positional) > 1 {
+			return usageError(fs, "unexpected argument %q", positional[1])
+		}
+		runs, err := state.ListRuns()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading runs: %v\n", err)
+			return exitError
+		}
+		if *jsonOutput {
+			if runs == nil {
+				runs = []state.RunOutcome{}
+			}
+			return printJSONReport(&runsReport{SchemaVersion: reportSchemaVersion, Runs: runs})
+		}
+		listRuns(runs)
+		return exitOK
+	case "show":
+		if len(positional) != 2 {
+			return usageError(fs, "expected 'ralphy runs show <id>'")
+		}
+		run, err := state.LoadRun(positional[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+		if *jsonOutput {
+			return printJSONReport(&runReport{SchemaVersion: reportSchemaVersion, ArchivedRun: run})
+		}
+		showRun(run)
+		return exitOK
+	}
+	return usageError(fs, "unknown runs command %q", positional[0])
+}
+
+func printJSONReport(v interface{}) int {
+	if err := printJSON(v, false); err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding runs: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func outcomeIcon(outcome string) string {
+	switch outcome {
+	case "completed":
+		return "✅"
+	case "max_iterations":
+		return "⏱️"
+	case "aborted":
+		return "💥"
+	case "budget":
+		return "💸"
+	}
+	return "🛑"
+}
+
+func listRuns(runs []state.RunOutcome) {
+	if len(runs) == 0 {
+		fmt.Println("No archived runs")
+		return
+	}
+
+	fmt.Printf("🗄️  RUNS (%d)\n\n", len(runs))
+	fmt.Printf("   %-20s  %-14s  %5s  %-10s  %-9s  %s\n", "ID", "OUTCOME", "ITERS", "TIME", "COST", "TASK")
+	for _, run := range runs {
+		cost := "-"
+		if run.CostUSD > 0 {
+			cost = tools.FormatCost(run.CostUSD)
+		}
+		fmt.Printf("%s %-20s  %-14s  %5d  %-10s  %-9s  %s\n",
+			outcomeIcon(run.Outcome), run.ID, run.Outcome, run.Iterations,
+			tools.FormatDurationLong(run.DurationMs), cost, truncate(run.Task, 60))
+	}
+}
+
+func showRun(run *state.ArchivedRun) {
+	fmt.Printf("%s RUN %s\n", outcomeIcon(run.Outcome), run.ID)
+	fmt.Printf("   Outcome:      %s\n", run.Outcome)
+	if run.Detail != "" {
+		fmt.Printf("   Detail:       %s\n", run.Detail)
+	}
+	fmt.Printf("   Started:      %s\n", run.StartedAt)
+	fmt.Printf("   Ended:        %s\n", run.EndedAt)
+	fmt.Printf("   Iterations:   %d\n", run.Iterations)
+	fmt.Printf("   Time:         %s\n", tools.FormatDurationLong(run.DurationMs))
+	if run.Tokens.Total() > 0 {
+		fmt.Printf("   Tokens:       %s\n", run.Tokens.Summary())
+	}
+	if run.CostUSD > 0 {
+		fmt.Printf("   Cost:         %s\n", tools.FormatCost(run.CostUSD))
+	}
+	if run.Agent != "" {
+		fmt.Printf("   Agent:        %s\n", run.Agent)
+	}
+	if run.Model != "" {
+		fmt.Printf("   Model:        %s\n", run.Model)
+	}
+	fmt.Printf("   Promise:      %s\n", run.CompletionPromise)
+	if run.TasksTotal > 0 {
+		fmt.Printf("   Tasks:        %d/%d complete\n", run.TasksComplete, run.TasksTotal)
+	}
+	fmt.Printf("   Archive:      %s\n", run.Dir)
+
+	fmt.Println("\n📝 PROMPT")
+	fmt.Println("   " + strings.ReplaceAll(strings.TrimSpace(run.Prompt), "\n", "\n   "))
+
+	if strings.TrimSpace(run.Tasks) != "" {
+		fmt.Println("\n📋 TASKS")
+		fmt.Println("   " + strings.ReplaceAll(strings.TrimSpace(run.Tasks), "\n", "\n   "))
+	}
+
+	fmt.Println("")
+	if run.History == nil || len(run.History.Iterations) == 0 {
+		fmt.Println("No iterations recorded")
+		return
+	}
+	printIterations(run.History, 0)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -10,15 +11,65 @@ import (
 	"github.com/wltechblog/ralphy/internal/tools"
 )
 
+func statusCommand(args []string) int {
+	fs := newFlagSet("status", "")
+	fs.Bool("tasks", false, "Show the task list (kept for compatibility; tasks are always shown)")
+	jsonOutput := fs.Bool("json", false, "Print the status as JSON")
+	watchMode := fs.Bool("watch", false, "Keep running and print the status again whenever it changes")
+	all := fs.Bool("all", false, "List every loop in the state directory")
+	positional, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 0 {
+		return usageError(fs, "unexpected argument %q", positional[0])
+	}
+
+	render := func() {
+		if *jsonOutput {
+			var report interface{} = buildStatusReport()
+			if *all {
+				report = buildLoopsReport()
+			}
+			if err := printJSON(report, *watchMode); err != nil {
+				fmt.Fprintf(os.Stderr, "Error encoding status: %v\n", err)
+			}
+			return
+		}
+		if *watchMode {
+			fmt.Print("\033[H\033[2J")
+		}
+		if *all {
+			printLoops()
+			return
+		}
+		printStatus()
+	}
+
+	if *watchMode {
+		watch(render)
+	}
+	render()
+	return exitOK
+}
+
 func printStatus() {
 	s, err := state.LoadState()
 	if err != nil {
+		if !errors.Is(err, state.ErrStateNotFound) {
+			warnCorrupt(err)
+			return
+		}
 		fmt.Println("⏹️  No active loop")
+		if loops, err := state.ListLoops(); err == nil && len(loops) > 0 && state.LoopName() == "" {
+			fmt.Printf("   %d named loop(s) in this state directory; see: ralphy status --all\n", len(loops))
+		}
 		return
 	}
 
 	h, err := state.LoadHistory()
 	if err != nil {
+		warnCorrupt(err)
 		h = &state.RalphHistory{}
 	}
 
@@ -38,7 +89,14 @@ func printStatus() {
 	if s != nil && s.Active {
 		startedAt, _ := time.Parse(time.RFC3339, s.StartedAt)
 		elapsed := time.Since(startedAt)
-		fmt.Println("🔄 ACTIVE LOOP")
+		if state.CheckOwner(s) == state.OwnerStale {
+			fmt.Println("💀 STALE LOOP (owner is gone; continue with: ralphy --resume)")
+		} else {
+			fmt.Println("🔄 ACTIVE LOOP")
+		}
+		if s.Name != "" {
+			fmt.Printf("   Loop:         %s\n", s.Name)
+		}
 		fmt.Printf("   Iteration:    %d", s.Iteration)
 		if s.MaxIterations > 0 {
 			fmt.Printf(" / %d", s.MaxIterations)
@@ -46,13 +104,60 @@ func printStatus() {
 		fmt.Println(" (unlimited)")
 		fmt.Printf("   Started:      %s\n", s.StartedAt)
 		fmt.Printf("   Elapsed:      %s\n", tools.FormatDurationLong(elapsed.Milliseconds()))
+		if s.PID != 0 {
+			owner := fmt.Sprintf("pid %d", s.PID)
+			if s.Hostname != "" {
+				owner += " on " + s.Hostname
+			}
+			if s.Version != "" {
+				owner += " (ralphy " + s.Version + ")"
+			}
+			fmt.Printf("   Owner:        %s\n", owner)
+		}
+		if s.HeartbeatAt != "" {
+			fmt.Printf("   Heartbeat:    %s ago\n", tools.FormatDurationLong(state.HeartbeatAge(s).Milliseconds()))
+		}
 		fmt.Printf("   Promise:      %s\n", s.CompletionPromise)
 		fmt.Printf("   Task Promise: %s\n", s.TaskPromise)
-		if s.Model != "" {
+		if s.Agent != "" {
+			fmt.Printf("   Agent:        %s\n", s.Agent)
+		}
+		if len(s.ModelChain) > 0 {
+			fmt.Printf("   Model:        %s (chain: %s)\n", s.Model, formatModelChain(s))
+		} else if s.Model != "" {
 			fmt.Printf("   Model:        %s\n", s.Model)
 		}
+		if s.Branch != "" {
+			branch := s.Branch
+			if s.BaseBranch != "" {
+				branch += " (from " + s.BaseBranch + ")"
+			}
+			if s.Merge != "" {
+				branch += ", " + s.Merge + " on completion"
+			}
+			fmt.Printf("   Branch:       %s\n", branch)
+		}
+		if s.Budget != nil {
+			fmt.Printf("   Budget:       %s\n", s.Budget.Summary())
+		}
+		if req, _ := state.LoadControl(); req != nil {
+			switch req.Action {
+			case state.ControlPause:
+				fmt.Printf("   Control:      ⏸️  paused (since %s)\n", req.RequestedAt)
+			case state.ControlStop:
+				fmt.Println("   Control:      🛑 stop requested after current iteration")
+			case state.ControlAbort:
+				fmt.Println("   Control:      🛑 abort requested")
+			}
+		}
 		preview := truncate(s.Prompt, 60)
 		fmt.Printf("   Prompt:       %s%s\n", preview, ellipsis(s.Prompt, 60))
+	} else if s != nil && s.StopReason != "" {
+		fmt.Printf("⏹️  No active loop (last session ended: %s)\n", s.StopReason)
+		if s.StopDetail != "" {
+			fmt.Printf("   Detail:       %s\n", s.StopDetail)
+		}
+		fmt.Println("   Continue it with: ralphy --resume")
 	} else {
 		fmt.Println("⏹️  No active loop")
 	}
@@ -114,17 +219,32 @@ func printStatus() {
 	if len(h.Iterations) > 0 {
 		fmt.Printf("\n📊 HISTORY (%d iterations)\n", len(h.Iterations))
 		fmt.Printf("   Total time:   %s\n", tools.FormatDurationLong(h.TotalDurationMs))
+		if h.TotalTokens.Total() > 0 {
+			fmt.Printf("   Tokens:       %s\n", h.TotalTokens.Summary())
+		}
+		if h.TotalCostUSD > 0 {
+			fmt.Printf("   Cost:         %s (%s per iteration)\n", tools.FormatCost(h.TotalCostUSD), tools.FormatCost(h.TotalCostUSD/float64(len(h.Iterations))))
+		}
+		if n := len(h.AgentFailures); n > 0 {
+			last := h.AgentFailures[n-1]
+			fmt.Printf("   Agent failures: %d (last: %s, %s)\n", n, last.Kind, truncate(last.Error, 60))
+		}
 
 		recent := h.Iterations
 		if len(recent) > 5 {
 			recent = recent[len(recent)-5:]
 		}
 		fmt.Println("\n   Recent iterations:")
+		showModels := usesSeveralModels(h)
 		for _, iter := range recent {
 			toolsSummary := tools.FormatToolSummary(iter.ToolsUsed, 3)
 			var status string
 			if iter.CompletionDetected {
 				status = "✅"
+			} else if iter.CompletionRejected {
+				status = "🚫"
+			} else if iter.TimedOut {
+				status = "⏱️"
 			} else if iter.ExitCode != 0 {
 				status = "❌"
 			} else {
@@ -133,9 +253,23 @@ func printStatus() {
 			if toolsSummary == "" {
 				toolsSummary = "no tools"
 			}
+			if iter.CompletionRejected {
+				toolsSummary += " | completion rejected by --verify"
+			}
+			if len(iter.Checks) > 0 {
+				toolsSummary += fmt.Sprintf(" | checks %d/%d", countPassed(iter.Checks), len(iter.Checks))
+			}
+			if iter.CostUSD > 0 {
+				toolsSummary += " | " + tools.FormatCost(iter.CostUSD)
+			}
+			if showModels && iter.Model != "" {
+				toolsSummary += " | " + iter.Model
+			}
 			fmt.Printf("   %s #%d: %s | %s\n", status, iter.Iteration, tools.FormatDurationLong(iter.DurationMs), toolsSummary)
 		}
 
+		printCheckTrend(h.Iterations, 10)
+
 		struggle := h.StruggleIndicators
 		if struggle.NoProgressIterations >= 3 || struggle.ShortIterations >= 3 || hasRepeatedErrors(struggle) {
 			fmt.Println("\n⚠️  STRUGGLE INDICATORS:")
@@ -149,13 +283,54 @@ func printStatus() {
 			for _, err := range topErrors {
 				fmt.Printf("   - Same error %dx: \"%s...\"\n", err.count, truncate(err.msg, 50))
 			}
-			fmt.Println("\n   💡 Consider using: ralph --add-context \"your hint here\"")
+			fmt.Println("\n   💡 Consider using: ralphy context add \"your hint here\"")
 		}
 	}
 
 	fmt.Println("")
 }
 
+func countPassed(checks []state.CheckResult) int {
+	passed := 0
+	for _, check := range checks {
+		if check.Passed {
+			passed++
+		}
+	}
+	return passed
+}
+
+// printCheckTrend shows one row per --check command with its pass/fail
+// result over the last n iterations, oldest first.
+func printCheckTrend(iterations []state.IterationHistory, n int) {
+	if len(iterations) > n {
+		iterations = iterations[len(iterations)-n:]
+	}
+
+	var commands []string
+	trends := map[string]string{}
+	for _, iter := range iterations {
+		for _, check := range iter.Checks {
+			if _, ok := trends[check.Command]; !ok {
+				commands = append(commands, check.Command)
+			}
+			if check.Passed {
+				trends[check.Command] += "✅"
+			} else {
+				trends[check.Command] += "❌"
+			}
+		}
+	}
+	if len(commands) == 0 {
+		return
+	}
+
+	fmt.Println("\n   Check trend (oldest → newest):")
+	for _, command := range commands {
+		fmt.Printf("   %s %s\n", trends[command], truncate(command, 50))
+	}
+}
+
 type errorCount struct {
 	msg   string
 	count int
@@ -196,3 +371,65 @@ func ellipsis(s string, maxLen int) string {
 	}
 	return "..."
 }
+
+// printLoops lists every loop in the state directory, one line each.
+func printLoops() {
+	loops, err := state.ListLoops()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading loops: %v\n", err)
+		return
+	}
+	if len(loops) == 0 {
+		fmt.Println("⏹️  No loops")
+		return
+	}
+
+	fmt.Printf("   %-20s %-10s %-10s %s\n", "LOOP", "ITERATION", "HISTORY", "PROMPT")
+	for _, l := range loops {
+		name := l.Name
+		if name == "" {
+			name = "(default)"
+		}
+		icon, iteration, prompt := "⏹️", "-", ""
+		switch {
+		case l.Problem != "":
+			icon, prompt = "⚠️", l.Problem
+		case l.State != nil:
+			iteration = fmt.Sprintf("%d", l.State.Iteration)
+			if l.State.MaxIterations > 0 {
+				iteration += fmt.Sprintf("/%d", l.State.MaxIterations)
+			}
+			prompt = truncate(strings.Join(strings.Fields(l.State.Prompt), " "), 50)
+			switch l.Owner {
+			case state.OwnerRunning:
+				icon = "🔄"
+			case state.OwnerStale:
+				icon = "💀"
+			}
+		}
+		fmt.Printf("%s %-20s %-10s %-10d %s\n", icon, name, iteration, l.Iterations, prompt)
+	}
+}
+
+// formatModelChain shows the chain with the current model in brackets.
+func formatModelChain(s *state.RalphState) string {
+	models := make([]string, len(s.ModelChain))
+	for i, model := range s.ModelChain {
+		if model == s.Model {
+			model = "[" + model + "]"
+		}
+		models[i] = model
+	}
+	return strings.Join(models, " → ")
+}
+
+// usesSeveralModels reports whether the iterations ran on more than one
+// model, as they do under --model-chain.
+func usesSeveralModels(h *state.RalphHistory) bool {
+	for _, iter := range h.Iterations {
+		if iter.Model != h.Iterations[0].Model {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+const tasksDetails = `
+Subcommands:
+  ls                  Display the current task list
+  add "desc"          Add a new task to the list
+  rm N                Remove task N and its subtasks
+  done N              Mark task N as complete
+
+`
+
+func tasksCommand(args []string) int {
+	fs := newFlagSet("tasks", tasksDetails)
+	positional, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) == 0 {
+		positional = []string{"ls"}
+	}
+
+	switch positional[0] {
+	case "ls", "list":
+		return listTasks()
+	case "add":
+		if len(positional) < 2 {
+			return usageError(fs, "tasks add requires a description")
+		}
+		description := strings.Join(positional[1:], " ")
+		if err := state.AddTask(description); err != nil {
+			fmt.Fprintf(os.Stderr, "Error adding task: %v\n", err)
+			return exitError
+		}
+		fmt.Printf("✅ Task added: \"%s\"\n", description)
+		return exitOK
+	case "rm", "remove":
+		index, err := taskIndex(positional)
+		if err != nil {
+			return usageError(fs, "%v", err)
+		}
+		if err := state.RemoveTask(index); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing task: %v\n", err)
+			return exitError
+		}
+		fmt.Printf("✅ Removed task %d and its subtasks\n", index)
+		return exitOK
+	case "done":
+		index, err := taskIndex(positional)
+		if err != nil {
+			return usageError(fs, "%v", err)
+		}
+		if err := state.CompleteTask(index); err != nil {
+			fmt.Fprintf(os.Stderr, "Error completing task: %v\n", err)
+			return exitError
+		}
+		fmt.Printf("✅ Marked task %d as complete\n", index)
+		return exitOK
+	}
+	return usageError(fs, "unknown tasks subcommand %q", positional[0])
+}
+
+func taskIndex(positional []string) (int, error) {
+	if len(positional) != 2 {
+		return 0, fmt.Errorf("tasks %s requires a task number", positional[0])
+	}
+	index, err := strconv.Atoi(positional[1])
+	if err != nil || index < 1 {
+		return 0, fmt.Errorf("invalid task number %q", positional[1])
+	}
+	return index, nil
+}
+
+func listTasks() int {
+	tasks, _, err := state.LoadTasks()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading tasks: %v\n", err)
+		return exitError
+	}
+	if len(tasks) == 0 {
+		fmt.Println("No tasks found. Use 'ralphy tasks add' to create your first task.")
+		return exitOK
+	}
+	fmt.Println("Current tasks:")
+	for i, task := range tasks {
+		statusIcon := "⏸️"
+		if task.Status == "complete" {
+			statusIcon = "✅"
+		} else if task.Status == "in-progress" {
+			statusIcon = "🔄"
+		}
+		fmt.Printf("%d. %s %s\n", i+1, statusIcon, task.Text)
+		for _, sub := range task.Subtasks {
+			subIcon := "⏸️"
+			if sub.Status == "complete" {
+				subIcon = "✅"
+			} else if sub.Status == "in-progress" {
+				subIcon = "🔄"
+			}
+			fmt.Printf("   %s %s\n", subIcon, sub.Text)
+		}
+	}
+	return exitOK
+}
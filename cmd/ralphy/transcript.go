@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/wltechblog/ralphy/internal/git"
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+const transcriptDetails = `
+Arguments:
+  N                   Iteration to show (default: the latest)
+
+Options:
+  --stderr            Use the agent's stderr instead of its stdout
+  --prompt            Use the prompt that was sent instead of the output
+  --raw               Print output with its ANSI escapes, as it was shown
+  --grep RE           Print the lines matching the regular expression, in
+                      iteration N or, without N, in every iteration
+  --diff M            Diff iteration N against iteration M
+  --run ID            Read the transcripts of an archived run (see 'ralphy runs')
+
+Examples:
+  ralphy transcript                     # output of the latest iteration
+  ralphy transcript 3 --prompt          # exact prompt sent in iteration 3
+  ralphy transcript --grep "FAIL|panic" # search every iteration
+  ralphy transcript 3 --diff 4          # what changed between 3 and 4
+
+`
+
+func transcriptCommand(args []string) int {
+	fs := newFlagSet("transcript", transcriptDetails)
+	useStderr := fs.Bool("stderr", false, "Use stderr instead of stdout")
+	usePrompt := fs.Bool("prompt", false, "Use the prompt instead of the output")
+	raw := fs.Bool("raw", false, "Keep ANSI escapes")
+	grep := fs.String("grep", "", "Print matching lines")
+	diffWith := fs.Int("diff", 0, "Diff against iteration M")
+	runID := fs.String("run", "", "Archived run to read")
+	positional, code, ok := parseArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 1 {
+		return usageError(fs, "unexpected argument %q", positional[1])
+	}
+
+	dir, err := state.GetTranscriptsDir()
+	if *runID != "" {
+		var run *state.ArchivedRun
+		run, err = state.LoadRun(*runID)
+		if err == nil {
+			dir = run.TranscriptsDir()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	iterations, err := state.ListTranscripts(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading transcripts: %v\n", err)
+		return exitError
+	}
+	if len(iterations) == 0 {
+		fmt.Println("No transcripts recorded")
+		if *runID == "" {
+			fmt.Println("Past runs keep theirs: ralphy runs, then ralphy transcript --run ID")
+		}
+		return exitOK
+	}
+
+	iteration := 0
+	if len(positional) == 1 {
+		iteration, err = strconv.Atoi(positional[0])
+		if err != nil || iteration < 1 {
+			return usageError(fs, "invalid iteration %q", positional[0])
+		}
+	}
+
+	pick := func(t *state.Transcript) string {
+		switch {
+		case *usePrompt:
+			return t.Prompt
+		case *useStderr && *raw:
+			return t.Stderr
+		case *useStderr:
+			return t.StderrPlain
+		case *raw:
+			return t.Stdout
+		}
+		return t.StdoutPlain
+	}
+
+	if *grep != "" {
+		re, err := regexp.Compile(*grep)
+		if err != nil {
+			return usageError(fs, "invalid --grep pattern: %v", err)
+		}
+		if iteration != 0 {
+			iterations = []int{iteration}
+		}
+		return grepTranscripts(dir, iterations, re, pick)
+	}
+
+	if iteration == 0 {
+		iteration = iterations[len(iterations)-1]
+	}
+	t, err := state.LoadTranscript(dir, iteration)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	if *diffWith != 0 {
+		other, err := state.LoadTranscript(dir, *diffWith)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+		return diffTranscripts(t, other, pick)
+	}
+
+	fmt.Print(pick(t))
+	return exitOK
+}
+
+func grepTranscripts(dir string, iterations []int, re *regexp.Regexp, pick func(*state.Transcript) string) int {
+	found := false
+	for _, iteration := range iterations {
+		t, err := state.LoadTranscript(dir, iteration)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+		for i, line := range strings.Split(pick(t), "\n") {
+			if re.MatchString(line) {
+				fmt.Printf("#%d:%d: %s\n", iteration, i+1, line)
+				found = true
+			}
+		}
+	}
+	if !found {
+		return exitError
+	}
+	return exitOK
+}
+
+// diffTranscripts shows how the picked text of iteration b differs from
+// iteration a.
+func diffTranscripts(a, b *state.Transcript, pick func(*state.Transcript) string) int {
+	tmp, err := os.MkdirTemp("", "ralphy-transcript-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	defer os.RemoveAll(tmp)
+
+	names := make([]string, 2)
+	for i, t := range []*state.Transcript{a, b} {
+		names[i] = fmt.Sprintf("iteration-%d", t.Iteration)
+		if err := os.WriteFile(filepath.Join(tmp, names[i]), []byte(pick(t)), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+	}
+
+	differ, err := git.DiffFiles(tmp, names[0], names[1], os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error running git diff: %v\n", err)
+		return exitError
+	}
+	if !differ {
+		fmt.Printf("Iterations %d and %d are identical\n", a.Iteration, b.Iteration)
+	}
+	return exitOK
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wltechblog/ralphy/internal/opencode"
+)
+
+const DefaultBackend = "opencode"
+
+type Options struct {
+	Prompt              string
+	Model               string
+	StreamOutput        bool
+	VerboseTools        bool
+	DisablePlugins      bool
+	AllowAllPermissions bool
+	IterationStart      time.Time
+	Verbose             bool
+	Timeout             time.Duration
+	Heartbeat           func()
+	OnTool              func(name string)
+	OnUsage             func(usage opencode.TokenUsage, cost float64)
+	Cancel              <-chan struct{}
+}
+
+type Result struct {
+	StdoutText string
+	StderrText string
+	ToolCounts map[string]int
+	ExitCode   int
+	Events     []opencode.Event
+	Usage      opencode.TokenUsage
+	Cost       float64
+}
+
+// partialResult returns what a run that did not finish had produced, or nil
+// when it produced nothing.
+func partialResult(r *opencode.StreamResult) *Result {
+	if r == nil {
+		return nil
+	}
+	return &Result{
+		StdoutText: r.StdoutText,
+		StderrText: r.StderrText,
+		ToolCounts: r.ToolCounts,
+		ExitCode:   -1,
+		Events:     r.Events,
+		Usage:      r.Usage,
+		Cost:       r.Cost,
+	}
+}
+
+// Config carries backend settings that are fixed for the lifetime of a loop.
+type Config struct {
+	Command      string
+	PromptVia    string
+	ToolPattern  string
+	JSONEvents   bool
+	ServerURL    string
+	ReuseSession bool
+}
+
+// Backend runs a single prompt against a coding agent, streaming its output
+// to the terminal and reporting what it did once the agent exits. A run that
+// is cancelled or times out also returns the output seen so far, if any.
+type Backend interface {
+	Name() string
+	Run(opts *Options) (*Result, error)
+}
+
+type Factory func(cfg *Config) (Backend, error)
+
+var backends = map[string]Factory{}
+
+func Register(name string, factory Factory) {
+	backends[name] = factory
+}
+
+func New(name string, cfg *Config) (Backend, error) {
+	if name == "" {
+		name = DefaultBackend
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	factory, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown agent %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return factory(cfg)
+}
+
+func toolMatcher(cfg *Config) (opencode.ToolMatcher, error) {
+	if cfg.ToolPattern == "" {
+		return nil, nil
+	}
+	return opencode.RegexToolMatcher(cfg.ToolPattern)
+}
+
+func Names() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,285 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/wltechblog/ralphy/internal/opencode"
+)
+
+const (
+	PromptViaArgv  = "argv"
+	PromptViaStdin = "stdin"
+	PromptViaFile  = "file"
+)
+
+func init() {
+	Register("generic", func(cfg *Config) (Backend, error) {
+		return newGenericBackend("generic", cfg, "", nil)
+	})
+	Register("claude", func(cfg *Config) (Backend, error) {
+		return newGenericBackend("claude", cfg, "claude --print --model {{model}} {{prompt}}", []string{"--dangerously-skip-permissions"})
+	})
+}
+
+// GenericBackend drives any CLI agent that takes a prompt and streams text.
+// The command line is a template; {{prompt}}, {{prompt_file}} and {{model}}
+// are substituted per run. A flag whose value expands to "" is dropped
+// together with its value, so "--model {{model}}" disappears when no model
+// is set.
+type GenericBackend struct {
+	name         string
+	argv         []string
+	promptVia    string
+	allowAllArgs []string
+	matchTool    opencode.ToolMatcher
+}
+
+func newGenericBackend(name string, cfg *Config, defaultCommand string, allowAllArgs []string) (Backend, error) {
+	command := cfg.Command
+	if command == "" {
+		command = defaultCommand
+	}
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("agent %q requires a command template (--agent-command)", name)
+	}
+
+	argv, err := splitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	promptVia := cfg.PromptVia
+	if promptVia == "" {
+		promptVia = PromptViaArgv
+		if strings.Contains(command, "{{prompt_file}}") {
+			promptVia = PromptViaFile
+		}
+	}
+	switch promptVia {
+	case PromptViaArgv, PromptViaStdin, PromptViaFile:
+	default:
+		return nil, fmt.Errorf("invalid prompt delivery %q (expected argv, stdin or file)", promptVia)
+	}
+
+	matchTool, err := toolMatcher(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GenericBackend{
+		name:         name,
+		argv:         argv,
+		promptVia:    promptVia,
+		allowAllArgs: allowAllArgs,
+		matchTool:    matchTool,
+	}, nil
+}
+
+func (b *GenericBackend) Name() string {
+	return b.name
+}
+
+func (b *GenericBackend) Run(opts *Options) (*Result, error) {
+	vars := map[string]string{
+		"model":  opts.Model,
+		"prompt": opts.Prompt,
+	}
+	// A prompt sent on stdin or in a file is not repeated on the command
+	// line, e.g. by the {{prompt}} of the claude preset.
+	if b.promptVia != PromptViaArgv {
+		vars["prompt"] = ""
+	}
+
+	if b.promptVia == PromptViaFile {
+		f, err := os.CreateTemp("", "ralphy-prompt-*.md")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create prompt file: %w", err)
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.WriteString(opts.Prompt); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write prompt file: %w", err)
+		}
+		f.Close()
+		vars["prompt_file"] = f.Name()
+	}
+
+	args := expandCommand(b.argv, vars)
+	if b.promptVia == PromptViaArgv && !templateUses(b.argv, "prompt") {
+		args = append(args, opts.Prompt)
+	}
+	if b.promptVia == PromptViaFile && !templateUses(b.argv, "prompt_file") {
+		args = append(args, vars["prompt_file"])
+	}
+	if opts.AllowAllPermissions && len(b.allowAllArgs) > 0 {
+		withFlags := []string{args[0]}
+		withFlags = append(withFlags, b.allowAllArgs...)
+		args = append(withFlags, args[1:]...)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = os.Environ()
+	opencode.SetProcessGroup(cmd)
+	if b.promptVia == PromptViaStdin {
+		cmd.Stdin = strings.NewReader(opts.Prompt)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", args[0], err)
+	}
+
+	defer opencode.KillProcessGroup(cmd, opencode.KillGracePeriod)
+
+	stopWatching := opencode.WatchCancel(cmd, opts.Cancel)
+	defer stopWatching()
+
+	var streamResult *opencode.StreamResult
+	if opts.StreamOutput {
+		streamResult, err = opencode.StreamProcessOutput(stdout, stderr, &opencode.StreamOptions{
+			CompactTools:   !opts.VerboseTools,
+			IterationStart: opts.IterationStart,
+			Timeout:        opts.Timeout,
+			MatchTool:      b.matchTool,
+			Heartbeat:      opts.Heartbeat,
+			OnTool:         opts.OnTool,
+			Cancel:         opts.Cancel,
+		})
+	} else {
+		streamResult, err = opencode.BufferProcessOutput(stdout, stderr, b.matchTool)
+	}
+	if err != nil {
+		return partialResult(streamResult), err
+	}
+
+	exitCode := 0
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		}
+	}
+
+	if opencode.Cancelled(opts.Cancel) {
+		return partialResult(streamResult), opencode.ErrCancelled
+	}
+
+	return &Result{
+		StdoutText: streamResult.StdoutText,
+		StderrText: streamResult.StderrText,
+		ToolCounts: streamResult.ToolCounts,
+		ExitCode:   exitCode,
+	}, nil
+}
+
+// expandCommand substitutes the placeholders of each argument in a single
+// left-to-right pass, so a value that contains a placeholder is kept as is.
+func expandCommand(argv []string, vars map[string]string) []string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		pairs = append(pairs, "{{"+name+"}}", vars[name])
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	var args []string
+	prevWasFlag := false
+	for _, arg := range argv {
+		if value, ok := placeholderValue(arg, vars); ok && value == "" {
+			if prevWasFlag {
+				args = args[:len(args)-1]
+			}
+			prevWasFlag = false
+			continue
+		}
+		args = append(args, replacer.Replace(arg))
+		prevWasFlag = strings.HasPrefix(arg, "-") && !strings.Contains(arg, "{{")
+	}
+	return args
+}
+
+// placeholderValue returns the value of arg when it is a placeholder on its
+// own, like "{{model}}".
+func placeholderValue(arg string, vars map[string]string) (string, bool) {
+	if !strings.HasPrefix(arg, "{{") || !strings.HasSuffix(arg, "}}") {
+		return "", false
+	}
+	value, ok := vars[arg[2:len(arg)-2]]
+	return value, ok
+}
+
+func templateUses(argv []string, name string) bool {
+	for _, arg := range argv {
+		if strings.Contains(arg, "{{"+name+"}}") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCommand splits a command template into arguments using shell-like
+// quoting rules (single quotes, double quotes and backslash escapes).
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' && i+1 < len(runes) {
+				i++
+				current.WriteRune(runes[i])
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == '\\' && i+1 < len(runes):
+			i++
+			current.WriteRune(runes[i])
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command %q", command)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command template")
+	}
+	return args, nil
+}
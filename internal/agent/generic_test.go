@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		argv string
+		vars map[string]string
+		want []string
+	}{
+		{
+			name: "placeholders",
+			argv: "tool --model {{model}} -p {{prompt}}",
+			vars: map[string]string{"model": "a/b", "prompt": "fix it"},
+			want: []string{"tool", "--model", "a/b", "-p", "fix it"},
+		},
+		{
+			name: "values are not expanded again",
+			argv: "tool {{prompt}} --model={{model}}",
+			vars: map[string]string{"model": "{{prompt}}", "prompt": "use {{model}}"},
+			want: []string{"tool", "use {{model}}", "--model={{prompt}}"},
+		},
+		{
+			name: "empty value drops its flag",
+			argv: "tool --model {{model}} {{prompt}}",
+			vars: map[string]string{"model": "", "prompt": "p"},
+			want: []string{"tool", "p"},
+		},
+		{
+			name: "empty value inside an argument",
+			argv: "tool --model={{model}}",
+			vars: map[string]string{"model": ""},
+			want: []string{"tool", "--model="},
+		},
+		{
+			name: "unknown placeholders are kept",
+			argv: "tool {{other}}",
+			vars: map[string]string{"model": "m"},
+			want: []string{"tool", "{{other}}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argv, err := splitCommand(tt.argv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expandCommand(argv, tt.vars); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenericPromptViaStdinNotInArgv(t *testing.T) {
+	// The claude preset's template, run through sh: the arguments after the
+	// model are printed, then stdin.
+	b, err := newGenericBackend("claude", &Config{
+		Command:   `sh -c 'echo "args: $*"; cat' sh --model {{model}} {{prompt}}`,
+		PromptVia: PromptViaStdin,
+	}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := b.Run(&Options{Prompt: "the prompt", Model: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StdoutText != "args: --model m\nthe prompt" {
+		t.Errorf("output = %q, want the prompt on stdin only", result.StdoutText)
+	}
+}
@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"github.com/wltechblog/ralphy/internal/opencode"
+)
+
+func init() {
+	Register("opencode", newOpenCodeBackend)
+}
+
+type OpenCodeBackend struct {
+	matchTool  opencode.ToolMatcher
+	jsonEvents bool
+}
+
+func newOpenCodeBackend(cfg *Config) (Backend, error) {
+	matchTool, err := toolMatcher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &OpenCodeBackend{matchTool: matchTool, jsonEvents: cfg.JSONEvents}, nil
+}
+
+func (b *OpenCodeBackend) Name() string {
+	return "opencode"
+}
+
+func (b *OpenCodeBackend) Run(opts *Options) (*Result, error) {
+	streamResult, exitCode, err := opencode.RunOpenCode(&opencode.RunOpenCodeOptions{
+		Prompt:              opts.Prompt,
+		Model:               opts.Model,
+		StreamOutput:        opts.StreamOutput,
+		VerboseTools:        opts.VerboseTools,
+		DisablePlugins:      opts.DisablePlugins,
+		AllowAllPermissions: opts.AllowAllPermissions,
+		IterationStart:      opts.IterationStart,
+		Verbose:             opts.Verbose,
+		Timeout:             opts.Timeout,
+		ToolMatcher:         b.matchTool,
+		JSONEvents:          b.jsonEvents,
+		Heartbeat:           opts.Heartbeat,
+		OnTool:              opts.OnTool,
+		OnUsage:             opts.OnUsage,
+		Cancel:              opts.Cancel,
+	})
+	if err != nil {
+		return partialResult(streamResult), err
+	}
+
+	return &Result{
+		StdoutText: streamResult.StdoutText,
+		StderrText: streamResult.StderrText,
+		ToolCounts: streamResult.ToolCounts,
+		ExitCode:   exitCode,
+		Events:     streamResult.Events,
+		Usage:      streamResult.Usage,
+		Cost:       streamResult.Cost,
+	}, nil
+}
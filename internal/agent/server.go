@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+
+	"github.com/wltechblog/ralphy/internal/opencode"
+)
+
+func init() {
+	Register("opencode-server", newServerBackend)
+}
+
+// ServerBackend talks to a long-lived `opencode serve` process instead of
+// spawning `opencode run` for every iteration. It attaches to ServerURL when
+// one is configured and otherwise starts its own server on first use.
+type ServerBackend struct {
+	serverURL    string
+	reuseSession bool
+
+	mu        sync.Mutex
+	server    *exec.Cmd
+	client    *opencode.ServerClient
+	sessionID string
+}
+
+func newServerBackend(cfg *Config) (Backend, error) {
+	return &ServerBackend{
+		serverURL:    cfg.ServerURL,
+		reuseSession: cfg.ReuseSession,
+	}, nil
+}
+
+func (b *ServerBackend) Name() string {
+	return "opencode-server"
+}
+
+func (b *ServerBackend) connect(opts *Options) (*opencode.ServerClient, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client != nil {
+		return b.client, nil
+	}
+
+	if b.serverURL != "" {
+		client := opencode.NewServerClient(b.serverURL)
+		if !client.Healthy() {
+			return nil, fmt.Errorf("opencode server at %s is not reachable", b.serverURL)
+		}
+		b.client = client
+		return client, nil
+	}
+
+	server, client, err := opencode.StartServer(&opencode.ServerOptions{
+		DisablePlugins:      opts.DisablePlugins,
+		AllowAllPermissions: opts.AllowAllPermissions,
+		Verbose:             opts.Verbose,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("🖥️  Started opencode server at %s\n", client.BaseURL)
+	b.server = server
+	b.client = client
+	return client, nil
+}
+
+func (b *ServerBackend) Run(opts *Options) (*Result, error) {
+	client, err := b.connect(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := b.session(client)
+	if err != nil {
+		return nil, err
+	}
+
+	streamResult, err := opencode.RunServerSession(&opencode.RunServerOptions{
+		Client:         client,
+		SessionID:      sessionID,
+		Prompt:         opts.Prompt,
+		Model:          opts.Model,
+		VerboseTools:   opts.VerboseTools,
+		Quiet:          !opts.StreamOutput,
+		IterationStart: opts.IterationStart,
+		Timeout:        opts.Timeout,
+		Heartbeat:      opts.Heartbeat,
+		OnTool:         opts.OnTool,
+		OnUsage:        opts.OnUsage,
+		Cancel:         opts.Cancel,
+	})
+	if err != nil {
+		return partialResult(streamResult), err
+	}
+	if !opts.StreamOutput {
+		fmt.Print(streamResult.StdoutText)
+	}
+
+	exitCode := 0
+	for _, event := range streamResult.Events {
+		if event.Type == opencode.EventError {
+			exitCode = 1
+		}
+	}
+
+	return &Result{
+		StdoutText: streamResult.StdoutText,
+		StderrText: streamResult.StderrText,
+		ToolCounts: streamResult.ToolCounts,
+		ExitCode:   exitCode,
+		Events:     streamResult.Events,
+		Usage:      streamResult.Usage,
+		Cost:       streamResult.Cost,
+	}, nil
+}
+
+// session returns the session to prompt: a new one for every iteration, or
+// the same one throughout with --reuse-session.
+func (b *ServerBackend) session(client *opencode.ServerClient) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.sessionID != "" && b.reuseSession {
+		return b.sessionID, nil
+	}
+	sessionID, err := client.CreateSession("ralphy")
+	if err != nil {
+		return "", err
+	}
+	b.sessionID = sessionID
+	return sessionID, nil
+}
+
+// Close stops the server if this backend started it.
+func (b *ServerBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.server == nil || b.server.Process == nil {
+		return nil
+	}
+	opencode.KillProcessGroup(b.server, opencode.KillGracePeriod)
+	b.server = nil
+	b.client = nil
+	return nil
+}
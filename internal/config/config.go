@@ -0,0 +1,282 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ProjectFileName     = ".ralphy.json"
+	ProjectTOMLFileName = "ralphy.toml"
+	userFileName        = "config.json"
+	userTOMLFileName    = "config.toml"
+	envPrefix           = "RALPHY_"
+	ProfileEnv          = "RALPHY_PROFILE"
+)
+
+const (
+	SourceDefault = "default"
+	SourceFlag    = "flag"
+)
+
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// File is a config file: option values keyed by flag name, plus named
+// profiles that override them. It is JSON, or TOML for .toml files:
+//
+//	{
+//	  "model": "anthropic/claude-sonnet",
+//	  "max-iterations": 20,
+//	  "verify": ["go test ./..."],
+//	  "profiles": {"ci": {"allow-all": true, "no-stream": true}}
+//	}
+//
+//	model = "anthropic/claude-sonnet"
+//	max-iterations = 20
+//	verify = ["go test ./..."]
+//
+//	[profiles.ci]
+//	allow-all = true
+//	no-stream = true
+type File struct {
+	Path     string
+	Values   map[string][]string
+	Profiles map[string]map[string][]string
+}
+
+// Setting is the effective value of one option and where it came from.
+type Setting struct {
+	Name   string
+	Value  string
+	Source string
+}
+
+// UserConfigPath returns $XDG_CONFIG_HOME/ralphy/config.json, falling back
+// to the platform's user config directory.
+func UserConfigPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		var err error
+		dir, err = os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, "ralphy", userFileName), nil
+}
+
+// UserConfigPaths returns the user config files that may exist: config.json
+// and config.toml next to UserConfigPath.
+func UserConfigPaths() ([]string, error) {
+	path, err := UserConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	return []string{path, filepath.Join(filepath.Dir(path), userTOMLFileName)}, nil
+}
+
+// LoadAny loads whichever of paths exists, or returns nil if none does. More
+// than one is an error, as it would be unclear which one applies.
+func LoadAny(paths ...string) (*File, error) {
+	var found *File
+	for _, path := range paths {
+		f, err := LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("both %s and %s exist; keep one", found.Path, f.Path)
+		}
+		found = f
+	}
+	return found, nil
+}
+
+// LoadFile reads a config file, as TOML if its name ends in .toml and as
+// JSON otherwise. A missing file is not an error; it returns nil.
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if filepath.Ext(path) == ".toml" {
+		raw, err = parseTOML(string(data))
+	} else {
+		err = json.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	f := &File{Path: path, Profiles: map[string]map[string][]string{}}
+	if value, ok := raw["profiles"]; ok {
+		profiles, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid profiles in %s: not a table of profiles", path)
+		}
+		for name, profile := range profiles {
+			options, ok := profile.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid profile %q in %s: not a table of options", name, path)
+			}
+			values, err := decodeValues(options)
+			if err != nil {
+				return nil, fmt.Errorf("invalid profile %q in %s: %w", name, path, err)
+			}
+			f.Profiles[name] = values
+		}
+		delete(raw, "profiles")
+	}
+
+	f.Values, err = decodeValues(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// decodeValues turns decoded values into the strings a flag would be set
+// to. Arrays become one value per element, for repeatable flags.
+func decodeValues(raw map[string]interface{}) (map[string][]string, error) {
+	values := map[string][]string{}
+	for key, value := range raw {
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			s, err := scalarString(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			values[key] = append(values[key], s)
+		}
+	}
+	return values, nil
+}
+
+func scalarString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+type layer struct {
+	source string
+	values map[string][]string
+}
+
+// Apply fills the flags in names that were not given on the command line,
+// taking each from the first layer that sets it: environment (RALPHY_<FLAG>),
+// the project config, then the user config. Within a file, the selected
+// profile overrides the file's top-level values. It returns the effective
+// setting of every name.
+func Apply(fs *flag.FlagSet, names []string, profile string, project, user *File) ([]Setting, error) {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var layers []layer
+	env := map[string][]string{}
+	for _, name := range names {
+		if value, ok := os.LookupEnv(EnvName(name)); ok {
+			env[name] = []string{value}
+		}
+	}
+	layers = append(layers, layer{source: "env", values: env})
+
+	profileFound := profile == ""
+	for _, f := range []*File{project, user} {
+		if f == nil {
+			continue
+		}
+		if profile != "" {
+			if values, ok := f.Profiles[profile]; ok {
+				profileFound = true
+				layers = append(layers, layer{source: fmt.Sprintf("%s [profile %s]", f.Path, profile), values: values})
+			}
+		}
+		layers = append(layers, layer{source: f.Path, values: f.Values})
+	}
+	if !profileFound {
+		return nil, fmt.Errorf("%w %q", ErrUnknownProfile, profile)
+	}
+
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, l := range layers {
+		for key := range l.values {
+			if !known[key] {
+				return nil, fmt.Errorf("unknown option %q in %s", key, l.source)
+			}
+		}
+	}
+
+	settings := []Setting{}
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil {
+			continue
+		}
+
+		source := SourceDefault
+		if explicit[name] {
+			source = SourceFlag
+		} else {
+			for _, l := range layers {
+				values, ok := l.values[name]
+				if !ok {
+					continue
+				}
+				for _, value := range values {
+					if err := fs.Set(name, value); err != nil {
+						return nil, fmt.Errorf("invalid value %q for %s in %s: %w", value, name, l.source, err)
+					}
+				}
+				source = l.source
+				if l.source == "env" {
+					source = "env " + EnvName(name)
+				}
+				break
+			}
+		}
+
+		settings = append(settings, Setting{Name: name, Value: f.Value.String(), Source: source})
+	}
+
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Name < settings[j].Name
+	})
+	return settings, nil
+}
+
+// EnvName is the environment variable that sets a flag, e.g. RALPHY_MAX_ITERATIONS.
+func EnvName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
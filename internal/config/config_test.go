@@ -0,0 +1,230 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]interface{}
+		err   string
+	}{
+		{
+			name:  "scalars",
+			input: "model = \"a/b\" # trailing comment\nmax-iterations = 1_000\nmax-cost = 2.5\nallow-all = true\ntimeout = '30m'\n",
+			want: map[string]interface{}{
+				"model": "a/b", "max-iterations": int64(1000), "max-cost": 2.5, "allow-all": true, "timeout": "30m",
+			},
+		},
+		{
+			name:  "escapes",
+			input: `check = "echo \"hi\"\tthere"`,
+			want:  map[string]interface{}{"check": "echo \"hi\"\tthere"},
+		},
+		{
+			name:  "multi-line array with trailing comma",
+			input: "verify = [\n  \"go test ./...\", # tests\n  'go vet ./...',\n]\n",
+			want:  map[string]interface{}{"verify": []interface{}{"go test ./...", "go vet ./..."}},
+		},
+		{
+			name:  "tables and dotted keys",
+			input: "[profiles.ci]\nallow-all = true\n\n[profiles]\nfast.\"max-iterations\" = 3\n",
+			want: map[string]interface{}{"profiles": map[string]interface{}{
+				"ci":   map[string]interface{}{"allow-all": true},
+				"fast": map[string]interface{}{"max-iterations": int64(3)},
+			}},
+		},
+		{name: "duplicate key", input: "a = 1\na = 2\n", err: "line 2: duplicate key a"},
+		{name: "missing equals", input: "a 1\n", err: "line 1: expected ="},
+		{name: "unterminated string", input: "a = \"b\n", err: "line 1: unterminated string"},
+		{name: "unterminated array", input: "a = [1,\n", err: "line 2: unterminated array"},
+		{name: "trailing garbage", input: "a = 1 2\n", err: "line 1: unexpected"},
+		{name: "inline table", input: "a = {b = 1}\n", err: "inline tables are not supported"},
+		{name: "array of tables", input: "[[a]]\n", err: "arrays of tables are not supported"},
+		{name: "key redefined as table", input: "a = 1\n[a.b]\n", err: "a is not a table"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(tt.input)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFileFormatsAgree(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "config.json")
+	tomlPath := filepath.Join(dir, "config.toml")
+	os.WriteFile(jsonPath, []byte(`{"model": "a/b", "max-iterations": 20, "verify": ["x", "y"], "profiles": {"ci": {"allow-all": true}}}`), 0644)
+	os.WriteFile(tomlPath, []byte("model = \"a/b\"\nmax-iterations = 20\nverify = [\"x\", \"y\"]\n[profiles.ci]\nallow-all = true\n"), 0644)
+
+	fromJSON, err := LoadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromTOML, err := LoadFile(tomlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON.Values, fromTOML.Values) || !reflect.DeepEqual(fromJSON.Profiles, fromTOML.Profiles) {
+		t.Errorf("JSON %v %v\nTOML %v %v", fromJSON.Values, fromJSON.Profiles, fromTOML.Values, fromTOML.Profiles)
+	}
+
+	if _, err := LoadAny(jsonPath, tomlPath); err == nil {
+		t.Error("LoadAny with both files: want an error")
+	}
+	if f, err := LoadAny(filepath.Join(dir, "missing.json"), tomlPath); err != nil || f.Path != tomlPath {
+		t.Errorf("LoadAny = %v, %v; want %s", f, err, tomlPath)
+	}
+}
+
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(v string) error { *l = append(*l, v); return nil }
+
+func TestApplyPrecedence(t *testing.T) {
+	project := &File{
+		Path:   ".ralphy.json",
+		Values: map[string][]string{"model": {"project"}, "timeout": {"10m"}, "verify": {"a", "b"}},
+		Profiles: map[string]map[string][]string{
+			"ci": {"timeout": {"1m"}},
+		},
+	}
+	user := &File{
+		Path:   "user.json",
+		Values: map[string][]string{"model": {"user"}, "agent": {"user-agent"}, "timeout": {"20m"}},
+		Profiles: map[string]map[string][]string{
+			"ci": {"agent": {"ci-agent"}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		profile string
+		want    map[string]Setting
+	}{
+		{
+			name: "files only",
+			want: map[string]Setting{
+				"model":   {Value: "project", Source: ".ralphy.json"},
+				"agent":   {Value: "user-agent", Source: "user.json"},
+				"timeout": {Value: "10m", Source: ".ralphy.json"},
+				"verify":  {Value: "a,b", Source: ".ralphy.json"},
+				"verbose": {Value: "false", Source: SourceDefault},
+			},
+		},
+		{
+			name: "env beats files",
+			env:  map[string]string{"RALPHY_MODEL": "env", "RALPHY_VERBOSE": "true"},
+			want: map[string]Setting{
+				"model":   {Value: "env", Source: "env RALPHY_MODEL"},
+				"verbose": {Value: "true", Source: "env RALPHY_VERBOSE"},
+			},
+		},
+		{
+			name: "flags beat env",
+			args: []string{"--model", "flag", "--verify", "c"},
+			env:  map[string]string{"RALPHY_MODEL": "env"},
+			want: map[string]Setting{
+				"model":  {Value: "flag", Source: SourceFlag},
+				"verify": {Value: "c", Source: SourceFlag},
+			},
+		},
+		{
+			name:    "profile beats its file but not a closer layer",
+			profile: "ci",
+			want: map[string]Setting{
+				"timeout": {Value: "1m", Source: ".ralphy.json [profile ci]"},
+				"agent":   {Value: "ci-agent", Source: "user.json [profile ci]"},
+				"model":   {Value: "project", Source: ".ralphy.json"},
+			},
+		},
+		{
+			name:    "env beats profile",
+			profile: "ci",
+			env:     map[string]string{"RALPHY_TIMEOUT": "5s"},
+			want: map[string]Setting{
+				"timeout": {Value: "5s", Source: "env RALPHY_TIMEOUT"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Unset the variables, restoring them after the test.
+			for _, name := range []string{"model", "agent", "timeout", "verify", "verbose"} {
+				t.Setenv(EnvName(name), "")
+				os.Unsetenv(EnvName(name))
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.String("model", "", "")
+			fs.String("agent", "", "")
+			fs.String("timeout", "1h", "")
+			fs.Bool("verbose", false, "")
+			var verify listFlag
+			fs.Var(&verify, "verify", "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			settings, err := Apply(fs, []string{"model", "agent", "timeout", "verify", "verbose"}, tt.profile, project, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]Setting{}
+			for _, s := range settings {
+				got[s.Name] = s
+			}
+			for name, want := range tt.want {
+				want.Name = name
+				if got[name] != want {
+					t.Errorf("%s = %+v, want %+v", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("max-iterations", 0, "")
+	names := []string{"max-iterations"}
+
+	_, err := Apply(fs, names, "nope", &File{Path: "p", Values: map[string][]string{}}, nil)
+	if !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("unknown profile: err = %v", err)
+	}
+	_, err = Apply(fs, names, "", &File{Path: "p", Values: map[string][]string{"modle": {"x"}}}, nil)
+	if err == nil || !strings.Contains(err.Error(), `unknown option "modle" in p`) {
+		t.Errorf("unknown option: err = %v", err)
+	}
+	_, err = Apply(fs, names, "", &File{Path: "p", Values: map[string][]string{"max-iterations": {"many"}}}, nil)
+	if err == nil || !strings.Contains(err.Error(), `invalid value "many" for max-iterations in p`) {
+		t.Errorf("invalid value: err = %v", err)
+	}
+}
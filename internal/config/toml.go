@@ -0,0 +1,261 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML decodes the subset of TOML a config file needs: key/value pairs,
+// dotted keys and [table] headers, with strings, integers, floats, booleans
+// and arrays of them as values. Tables become map[string]interface{}, arrays
+// []interface{} and integers int64.
+func parseTOML(data string) (map[string]interface{}, error) {
+	p := &tomlParser{data: data, line: 1}
+	root := map[string]interface{}{}
+	current := root
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			p.pos++
+			if p.peek() == '[' {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			path, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skipBlank(false)
+			if p.peek() != ']' {
+				return nil, p.errorf("expected ] after table name")
+			}
+			p.pos++
+			if current, err = p.table(root, path); err != nil {
+				return nil, err
+			}
+		} else {
+			path, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skipBlank(false)
+			if p.peek() != '=' {
+				return nil, p.errorf("expected = after key %s", strings.Join(path, "."))
+			}
+			p.pos++
+			p.skipBlank(false)
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			table, err := p.table(current, path[:len(path)-1])
+			if err != nil {
+				return nil, err
+			}
+			name := path[len(path)-1]
+			if _, ok := table[name]; ok {
+				return nil, p.errorf("duplicate key %s", strings.Join(path, "."))
+			}
+			table[name] = value
+		}
+
+		p.skipBlank(false)
+		if !p.eof() && p.peek() != '\n' {
+			return nil, p.errorf("unexpected %q at end of line", p.peek())
+		}
+	}
+}
+
+type tomlParser struct {
+	data string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.data) }
+func (p *tomlParser) peek() byte { return p.data[p.pos] }
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skipBlank skips spaces and comments, and newlines too when newlines is set.
+func (p *tomlParser) skipBlank(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// table returns the table at path below root, creating missing ones.
+func (p *tomlParser) table(root map[string]interface{}, path []string) (map[string]interface{}, error) {
+	t := root
+	for _, name := range path {
+		switch v := t[name].(type) {
+		case nil:
+			next := map[string]interface{}{}
+			t[name] = next
+			t = next
+		case map[string]interface{}:
+			t = v
+		default:
+			return nil, p.errorf("%s is not a table", name)
+		}
+	}
+	return t, nil
+}
+
+// key reads a dotted key of bare or quoted parts.
+func (p *tomlParser) key() ([]string, error) {
+	var path []string
+	for {
+		p.skipBlank(false)
+		if p.eof() {
+			return nil, p.errorf("expected a key")
+		}
+		var part string
+		switch p.peek() {
+		case '"', '\'':
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("unexpected %q in key", p.peek())
+			}
+			part = p.data[start:p.pos]
+		}
+		path = append(path, part)
+
+		p.skipBlank(false)
+		if p.eof() || p.peek() != '.' {
+			return path, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+	if p.eof() {
+		return nil, p.errorf("expected a value")
+	}
+	switch p.peek() {
+	case '"', '\'':
+		return p.str()
+	case '[':
+		return p.array()
+	case '{':
+		return nil, p.errorf("inline tables are not supported; use a [table] header")
+	}
+
+	start := p.pos
+	for !p.eof() && strings.IndexByte(" \t\r\n#,]", p.peek()) < 0 {
+		p.pos++
+	}
+	token := p.data[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	number := strings.ReplaceAll(token, "_", "")
+	if i, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	return nil, p.errorf("invalid value %q", token)
+}
+
+// str reads a basic ("...") or literal ('...') single-line string.
+func (p *tomlParser) str() (string, error) {
+	quote := p.peek()
+	if strings.HasPrefix(p.data[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", p.errorf("multi-line strings are not supported")
+	}
+	p.pos++
+	start := p.pos
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		if c == quote {
+			break
+		}
+		if c == '\\' && quote == '"' {
+			p.pos++
+		}
+		p.pos++
+	}
+	raw := p.data[start:p.pos]
+	p.pos++
+	if quote == '\'' {
+		return raw, nil
+	}
+	s, err := strconv.Unquote(`"` + raw + `"`)
+	if err != nil {
+		return "", p.errorf("invalid string \"%s\"", raw)
+	}
+	return s, nil
+}
+
+// array reads an array, which may span lines and end with a comma.
+func (p *tomlParser) array() ([]interface{}, error) {
+	p.pos++
+	items := []interface{}{}
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return items, nil
+		}
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		p.skipBlank(true)
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expected , or ] in array")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Ways to bring a loop's branch back into its base branch.
+const (
+	MergeSquash = "squash"
+	MergeCommit = "merge"
+)
+
+// run runs git and returns its trimmed output. A failure carries git's
+// message, which some commands, like merge on a conflict, print to stdout.
+func run(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(string(output))
+		}
+		if msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// CurrentBranch returns the name of the checked out branch. It fails when
+// HEAD is detached or the directory is not a repository.
+func CurrentBranch() (string, error) {
+	return run("symbolic-ref", "--short", "HEAD")
+}
+
+func BranchExists(name string) bool {
+	_, err := run("rev-parse", "--verify", "--quiet", "refs/heads/"+name)
+	return err == nil
+}
+
+// CreateBranch creates name at HEAD and checks it out, keeping any
+// uncommitted changes.
+func CreateBranch(name string) error {
+	_, err := run("checkout", "-b", name)
+	return err
+}
+
+func Checkout(name string) error {
+	_, err := run("checkout", name)
+	return err
+}
+
+// MergeBranch brings branch into the checked out branch as one commit with
+// message: a squash of its changes, or a merge commit that keeps its
+// history. It reports whether there was anything to commit. A merge that
+// fails is undone.
+func MergeBranch(branch, mode, message string) (bool, error) {
+	if mode == MergeSquash {
+		if _, err := run("merge", "--squash", branch); err != nil {
+			run("reset", "--merge")
+			return false, err
+		}
+		if _, err := run("diff", "--cached", "--quiet"); err == nil {
+			return false, nil
+		}
+		if _, err := run("commit", "-m", message); err != nil {
+			run("reset", "--merge")
+			return false, err
+		}
+		return true, nil
+	}
+	before, _ := HeadCommit()
+	if _, err := run("merge", "--no-ff", "-m", message, branch); err != nil {
+		run("merge", "--abort")
+		return false, err
+	}
+	after, _ := HeadCommit()
+	return after != before, nil
+}
@@ -0,0 +1,26 @@
+package git
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+)
+
+// DiffFiles writes a unified diff between two files to w, using `git diff
+// --no-index` so no repository is needed. dir is the working directory the
+// paths are relative to. It reports whether the files differ.
+func DiffFiles(dir, oldPath, newPath string, w io.Writer) (bool, error) {
+	cmd := exec.Command("git", "diff", "--no-index", "--", oldPath, newPath)
+	cmd.Dir = dir
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err := cmd.Run()
+	if err == nil {
+		return false, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return true, nil
+	}
+	return false, err
+}
@@ -112,3 +112,12 @@ func AutoCommit(message string) (bool, error) {
 
 	return true, nil
 }
+
+// HeadCommit returns the hash of the commit HEAD points to.
+func HeadCommit() (string, error) {
+	output, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,118 @@
+package loop
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wltechblog/ralphy/internal/git"
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+// AutoBranch as --branch names the loop's branch after its run id.
+const AutoBranch = "auto"
+
+// setupBranch checks out the branch the loop commits to, creating it from
+// the current branch for a new loop. A resumed loop goes back to the branch
+// it was using.
+func setupBranch(s *state.RalphState, opts *LoopOptions) error {
+	if s.Branch == "" {
+		if opts.Branch == "" {
+			return nil
+		}
+		base, err := git.CurrentBranch()
+		if err != nil {
+			return fmt.Errorf("--branch needs a checked out branch to start from: %w", err)
+		}
+		s.Branch = opts.Branch
+		if s.Branch == AutoBranch {
+			s.Branch = "ralphy/" + s.RunID
+		}
+		if base == s.Branch && opts.Merge != "" {
+			return fmt.Errorf("already on %s; check out the branch to merge into first", s.Branch)
+		}
+		if base != s.Branch {
+			s.BaseBranch = base
+		}
+	}
+	s.Merge = opts.Merge
+
+	current, _ := git.CurrentBranch()
+	switch {
+	case current == s.Branch:
+	case git.BranchExists(s.Branch):
+		if err := git.Checkout(s.Branch); err != nil {
+			return fmt.Errorf("failed to check out %s: %w", s.Branch, err)
+		}
+	default:
+		if err := git.CreateBranch(s.Branch); err != nil {
+			return fmt.Errorf("failed to create branch %s: %w", s.Branch, err)
+		}
+	}
+	return nil
+}
+
+// finishBranch brings a completed loop's branch back into its base branch,
+// as one squashed commit or a merge commit. When that fails the loop's
+// branch stays checked out with all its work, to be merged by hand.
+func finishBranch(s *state.RalphState) {
+	if s.Branch == "" || s.Merge == "" || s.BaseBranch == "" {
+		return
+	}
+	// The loop's own files change after the last iteration's commit, and
+	// would block the checkout. No event is emitted, as that would change
+	// the event log again.
+	if _, err := git.AutoCommit("Ralph: loop finished"); err != nil {
+		fmt.Printf("⚠️  Git auto-commit failed: %v\n", err)
+	}
+
+	message := mergeMessage(s)
+	if err := git.Checkout(s.BaseBranch); err != nil {
+		fmt.Printf("⚠️  Failed to check out %s: %v\n", s.BaseBranch, err)
+		fmt.Printf("   The work is on branch %s\n", s.Branch)
+		return
+	}
+	merged, err := git.MergeBranch(s.Branch, s.Merge, message)
+	if err != nil {
+		fmt.Printf("⚠️  Failed to %s %s into %s: %v\n", s.Merge, s.Branch, s.BaseBranch, err)
+		if err := git.Checkout(s.Branch); err != nil {
+			fmt.Printf("⚠️  Failed to check out %s: %v\n", s.Branch, err)
+		}
+		fmt.Printf("   The work is on branch %s; merge it by hand\n", s.Branch)
+		return
+	}
+	if !merged {
+		fmt.Printf("🌿 Nothing to merge: %s has no changes for %s\n", s.Branch, s.BaseBranch)
+		return
+	}
+	verb := "Squashed"
+	if s.Merge == git.MergeCommit {
+		verb = "Merged"
+	}
+	fmt.Printf("🌿 %s %s into %s (branch kept)\n", verb, s.Branch, s.BaseBranch)
+	commit, _ := git.HeadCommit()
+	emit(s, &state.Event{Type: state.EventCommitCreated, Iteration: s.Iteration, Commit: commit, Message: message})
+}
+
+// mergeMessage summarises the loop for the commit on the base branch: the
+// task, the tasks completed and where the work came from.
+func mergeMessage(s *state.RalphState) string {
+	var b strings.Builder
+	b.WriteString("Ralph: " + state.SummariseTask(s.Prompt) + "\n")
+
+	tasks, _, _ := state.LoadTasks()
+	var done []string
+	for _, task := range tasks {
+		if task.Status == "complete" {
+			done = append(done, task.Text)
+		}
+	}
+	if len(done) > 0 {
+		b.WriteString("\nCompleted tasks:\n")
+		for _, text := range done {
+			b.WriteString("- " + text + "\n")
+		}
+	}
+
+	fmt.Fprintf(&b, "\nRun %s, %d iteration(s) on branch %s\n", s.RunID, s.Iteration, s.Branch)
+	return b.String()
+}
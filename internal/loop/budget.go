@@ -0,0 +1,139 @@
+package loop
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wltechblog/ralphy/internal/agent"
+	"github.com/wltechblog/ralphy/internal/opencode"
+	"github.com/wltechblog/ralphy/internal/pricing"
+	"github.com/wltechblog/ralphy/internal/state"
+	"github.com/wltechblog/ralphy/internal/tools"
+)
+
+// budgetGuard enforces the loop's budget. It is checked between iterations
+// and, while an iteration runs, on every heartbeat and reported step. Each
+// warning threshold is announced once; a hard budget also cancels the
+// running iteration when a limit is reached.
+type budgetGuard struct {
+	limits state.Budget
+	h      *state.RalphHistory
+	prices pricing.Table
+	sd     *shutdown
+	// origin is when the loop would have started had all its sessions run
+	// back to back; --max-duration counts the wall-clock time since.
+	origin time.Time
+
+	mu       sync.Mutex
+	warned   map[string]int
+	exceeded string
+	model    string
+	usage    opencode.TokenUsage
+	cost     float64
+}
+
+func newBudgetGuard(limits state.Budget, s *state.RalphState, h *state.RalphHistory, prices pricing.Table, sd *shutdown) *budgetGuard {
+	limits.WarnAt = append([]int(nil), limits.WarnAt...)
+	sort.Ints(limits.WarnAt)
+	origin := time.Now().Add(-state.Elapsed(s))
+	return &budgetGuard{limits: limits, h: h, prices: prices, sd: sd, origin: origin, warned: map[string]int{}}
+}
+
+// startIteration resets the usage seen so far for a new iteration.
+func (g *budgetGuard) startIteration(model string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.model = model
+	g.usage = opencode.TokenUsage{}
+	g.cost = 0
+}
+
+// onUsage receives the running totals of the current iteration.
+func (g *budgetGuard) onUsage(usage opencode.TokenUsage, cost float64) {
+	g.mu.Lock()
+	g.usage = usage
+	g.cost = cost
+	g.mu.Unlock()
+	g.checkLive()
+}
+
+func (g *budgetGuard) checkLive() {
+	g.mu.Lock()
+	usage := stateUsage(g.usage)
+	cost, _ := priceUsage(usage, g.cost, g.model, g.prices)
+	wasExceeded := g.exceeded != ""
+	exceeded := g.check(time.Since(g.origin), g.h.TotalCostUSD+cost, g.h.TotalTokens.Total()+usage.Total())
+	detail := g.exceeded
+	g.mu.Unlock()
+
+	if exceeded && !wasExceeded && g.limits.Hard {
+		fmt.Printf("\n💸 Budget exceeded: %s. Stopping the agent...\n", detail)
+		g.sd.trigger(StopBudget, nil)
+	}
+}
+
+// checkHistory checks the finished iterations and returns what was exceeded.
+func (g *budgetGuard) checkHistory() (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.check(time.Since(g.origin), g.h.TotalCostUSD, g.h.TotalTokens.Total()) {
+		return g.exceeded, true
+	}
+	return "", false
+}
+
+// partialUsage returns what an iteration that did not finish used so far.
+func (g *budgetGuard) partialUsage() *agent.Result {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return &agent.Result{Usage: g.usage, Cost: g.cost}
+}
+
+// finishOverBudget ends a loop that ran out of budget. Like a stop request
+// it keeps the state, so the loop can be resumed with a larger budget.
+func finishOverBudget(s *state.RalphState, h *state.RalphHistory, g *budgetGuard) *LoopResult {
+	g.mu.Lock()
+	s.StopDetail = g.exceeded
+	g.mu.Unlock()
+	return finishEarly(s, h, StopBudget, nil)
+}
+
+// check compares spending with the limits, printing warnings as thresholds
+// are crossed. The caller holds g.mu.
+func (g *budgetGuard) check(elapsed time.Duration, cost float64, tokens int64) bool {
+	if g.exceeded != "" {
+		return true
+	}
+	limits := []struct {
+		flag        string
+		used, limit float64
+		format      func(float64) string
+	}{
+		{"--max-duration", float64(elapsed.Milliseconds()), float64(g.limits.MaxDurationMs), func(v float64) string { return tools.FormatDurationLong(int64(v)) }},
+		{"--max-cost", cost, g.limits.MaxCostUSD, tools.FormatCost},
+		{"--max-tokens", float64(tokens), float64(g.limits.MaxTokens), func(v float64) string { return tools.FormatTokens(int64(v)) }},
+	}
+	for _, l := range limits {
+		if l.limit <= 0 {
+			continue
+		}
+		if l.used >= l.limit {
+			g.exceeded = fmt.Sprintf("%s of %s used (%s)", l.format(l.used), l.format(l.limit), l.flag)
+			return true
+		}
+		percent := int(l.used / l.limit * 100)
+		threshold := 0
+		for _, at := range g.limits.WarnAt {
+			if percent >= at {
+				threshold = at
+			}
+		}
+		if threshold > g.warned[l.flag] {
+			g.warned[l.flag] = threshold
+			fmt.Printf("\n⚠️  Budget: %d%% of %s used (%s of %s)\n", percent, l.flag, l.format(l.used), l.format(l.limit))
+		}
+	}
+	return false
+}
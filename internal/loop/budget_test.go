@@ -0,0 +1,68 @@
+package loop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+func TestBudgetCheck(t *testing.T) {
+	limits := state.Budget{MaxDurationMs: time.Hour.Milliseconds(), MaxCostUSD: 5, MaxTokens: 1000, WarnAt: []int{80, 50}}
+	tests := []struct {
+		name     string
+		elapsed  time.Duration
+		cost     float64
+		tokens   int64
+		exceeded string
+		warned   map[string]int
+	}{
+		{name: "under every limit", elapsed: time.Minute, cost: 1, tokens: 100, warned: map[string]int{}},
+		{
+			name:    "warnings at the highest threshold crossed",
+			elapsed: 50 * time.Minute, cost: 2.5, tokens: 100,
+			warned: map[string]int{"--max-duration": 80, "--max-cost": 50},
+		},
+		{name: "duration", elapsed: time.Hour, exceeded: "1h 0m 0s of 1h 0m 0s used (--max-duration)"},
+		{name: "cost", cost: 5.01, exceeded: "$5.01 of $5.00 used (--max-cost)"},
+		{name: "tokens", tokens: 1000, exceeded: "1.0k of 1.0k used (--max-tokens)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newBudgetGuard(limits, &state.RalphState{}, &state.RalphHistory{}, nil, newShutdown())
+			exceeded := g.check(tt.elapsed, tt.cost, tt.tokens)
+			if exceeded != (tt.exceeded != "") || g.exceeded != tt.exceeded {
+				t.Errorf("check = %v, %q; want %q", exceeded, g.exceeded, tt.exceeded)
+			}
+			if tt.warned != nil && len(g.warned) != len(tt.warned) {
+				t.Errorf("warned = %v, want %v", g.warned, tt.warned)
+			}
+			for flag, at := range tt.warned {
+				if g.warned[flag] != at {
+					t.Errorf("warned = %v, want %v", g.warned, tt.warned)
+				}
+			}
+		})
+	}
+}
+
+func TestBudgetCountsEarlierSessions(t *testing.T) {
+	limits := state.Budget{MaxDurationMs: time.Hour.Milliseconds()}
+	s := &state.RalphState{ElapsedMs: 59 * time.Minute.Milliseconds()}
+	state.ClaimOwnership(s)
+	if _, exceeded := newBudgetGuard(limits, s, &state.RalphHistory{}, nil, newShutdown()).checkHistory(); exceeded {
+		t.Fatal("59m of 1h: want within budget")
+	}
+
+	// The previous session's process died 2m in, after its last heartbeat.
+	s.SessionStartedAt = time.Now().Add(-10 * time.Minute).Format(time.RFC3339)
+	s.HeartbeatAt = time.Now().Add(-8 * time.Minute).Format(time.RFC3339)
+	state.ClaimOwnership(s)
+	if s.ElapsedMs != 61*time.Minute.Milliseconds() {
+		t.Fatalf("ElapsedMs = %d, want 61m", s.ElapsedMs)
+	}
+	detail, exceeded := newBudgetGuard(limits, s, &state.RalphHistory{}, nil, newShutdown()).checkHistory()
+	if !exceeded || detail != "1h 1m 0s of 1h 0m 0s used (--max-duration)" {
+		t.Errorf("checkHistory = %q, %v", detail, exceeded)
+	}
+}
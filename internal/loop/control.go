@@ -0,0 +1,230 @@
+package loop
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+const controlPollInterval = 1 * time.Second
+
+const (
+	StopCompleted     = "completed"
+	StopMaxIterations = "max_iterations"
+	StopRequested     = "stopped"
+	StopAborted       = "aborted"
+	StopSignal        = "signal"
+	StopBudget        = "budget"
+	// StopReplaced is the outcome of a stale loop that a new one replaced.
+	StopReplaced = "replaced"
+)
+
+// LoopResult describes why a loop ended.
+type LoopResult struct {
+	StopReason string
+	Signal     os.Signal
+	Iterations int
+}
+
+// ExitCode maps the stop reason to the process exit code: 0 on completion,
+// 2 when the iteration limit was hit, 3 for --stop, 4 for --abort, 5 when
+// the budget ran out and the conventional 128+N when a signal ended the loop.
+func (r *LoopResult) ExitCode() int {
+	switch r.StopReason {
+	case StopCompleted:
+		return 0
+	case StopMaxIterations:
+		return 2
+	case StopRequested:
+		return 3
+	case StopAborted:
+		return 4
+	case StopBudget:
+		return 5
+	case StopSignal:
+		if sig, ok := r.Signal.(syscall.Signal); ok {
+			return 128 + int(sig)
+		}
+		return 130
+	}
+	return 1
+}
+
+// shutdown records the first request to end the loop early, whether it came
+// from a signal or from `ralphy --abort`, and cancels the running iteration.
+type shutdown struct {
+	once   sync.Once
+	cancel chan struct{}
+
+	mu     sync.Mutex
+	reason string
+	signal os.Signal
+}
+
+func newShutdown() *shutdown {
+	return &shutdown{cancel: make(chan struct{})}
+}
+
+func (sd *shutdown) trigger(reason string, sig os.Signal) {
+	sd.once.Do(func() {
+		sd.mu.Lock()
+		sd.reason = reason
+		sd.signal = sig
+		sd.mu.Unlock()
+		close(sd.cancel)
+	})
+}
+
+func (sd *shutdown) requested() (string, os.Signal, bool) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	return sd.reason, sd.signal, sd.reason != ""
+}
+
+// watchControl polls for control requests while the loop runs and triggers
+// a shutdown as soon as an abort is requested, so the running agent is killed.
+func watchControl(sd *shutdown, done <-chan struct{}) {
+	ticker := time.NewTicker(controlPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-sd.cancel:
+			return
+		case <-ticker.C:
+			req, _ := state.LoadControl()
+			if req != nil && req.Action == state.ControlAbort {
+				sd.trigger(StopAborted, nil)
+				return
+			}
+		}
+	}
+}
+
+// iterationDeadline returns a channel that closes when cancel does or, with
+// a positive limit, once the iteration has run for limit. expired reports
+// whether the limit closed it; stop releases the timer.
+func iterationDeadline(cancel <-chan struct{}, limit time.Duration) (ch <-chan struct{}, expired func() bool, stop func()) {
+	if limit <= 0 {
+		return cancel, func() bool { return false }, func() {}
+	}
+
+	deadline := make(chan struct{})
+	done := make(chan struct{})
+	var timedOut atomic.Bool
+	go func() {
+		timer := time.NewTimer(limit)
+		defer timer.Stop()
+		select {
+		case <-cancel:
+		case <-timer.C:
+			timedOut.Store(true)
+		case <-done:
+			return
+		}
+		close(deadline)
+	}()
+	return deadline, timedOut.Load, func() { close(done) }
+}
+
+// waitWhilePaused holds the loop for as long as a pause is requested. It
+// returns the pending stop or abort request, if any.
+func waitWhilePaused(s *state.RalphState, sd *shutdown) *state.ControlRequest {
+	req, _ := state.LoadControl()
+	if req == nil || req.Action != state.ControlPause {
+		return req
+	}
+
+	fmt.Printf("\n⏸️  Loop paused before iteration %d. Run 'ralphy --unpause' to continue.\n", s.Iteration)
+	lastHeartbeat := time.Now()
+	for {
+		select {
+		case <-sd.cancel:
+			return nil
+		case <-time.After(controlPollInterval):
+		}
+		if time.Since(lastHeartbeat) >= 10*time.Second {
+			state.Heartbeat(s)
+			lastHeartbeat = time.Now()
+		}
+
+		req, _ = state.LoadControl()
+		if req == nil {
+			fmt.Println("▶️  Loop unpaused.")
+			return nil
+		}
+		if req.Action != state.ControlPause {
+			return req
+		}
+	}
+}
+
+// finishEarly ends the loop after a stop request, an abort, a signal or an
+// exhausted budget. The run is archived, and the state is kept (inactive) so
+// the loop can be picked up again with --resume.
+func finishEarly(s *state.RalphState, h *state.RalphHistory, reason string, sig os.Signal) *LoopResult {
+	state.ClearControl()
+	s.Active = false
+	state.EndSession(s)
+
+	var message string
+	resume := "Continue later with: ralphy --resume"
+	switch reason {
+	case StopRequested:
+		message = "Stop requested"
+	case StopAborted:
+		message = "Abort requested"
+	case StopBudget:
+		message = "Budget exceeded: " + s.StopDetail
+		resume = "Raise the limit and continue with: ralphy --resume"
+	default:
+		message = fmt.Sprintf("Received %v", sig)
+	}
+
+	fmt.Println("\n╔══════════════════════════════════════════════════════════════════╗")
+	fmt.Printf("║  🛑 %s. Loop stopped before iteration %d.\n", message, s.Iteration)
+	fmt.Printf("║  Total time: %s\n", formatDurationLong(h.TotalDurationMs))
+	printTotalCost(h)
+	fmt.Printf("║  %s\n", resume)
+	fmt.Println("╚══════════════════════════════════════════════════════════════════╝")
+
+	archiveRun(s, h, reason)
+	state.SaveState(s)
+	state.SaveHistory(h)
+
+	return &LoopResult{StopReason: reason, Signal: sig, Iterations: len(h.Iterations)}
+}
+
+// archiveReplaced archives a stale loop before a new loop takes its place,
+// as its owner died before it could.
+func archiveReplaced(s *state.RalphState) {
+	h, err := state.LoadHistory()
+	if err != nil {
+		if h, err = state.RebuildHistory(s.RunID); err != nil {
+			h = &state.RalphHistory{}
+		}
+	}
+	s.Active = false
+	archiveRun(s, h, StopReplaced)
+}
+
+// archiveRun logs the end of the loop and keeps a copy of it under
+// .opencode/ralph-runs so it can be browsed with `ralphy runs` once the live
+// state is gone.
+func archiveRun(s *state.RalphState, h *state.RalphHistory, outcome string) {
+	s.StopReason = outcome
+	emit(s, &state.Event{Type: state.EventLoopFinished, Outcome: outcome, Iterations: len(h.Iterations)})
+	run, err := state.ArchiveRun(s, h, outcome)
+	if err != nil {
+		fmt.Printf("⚠️  Failed to archive run: %v\n", err)
+		return
+	}
+	fmt.Printf("🗄️  Run archived as %s (ralphy runs show %s)\n", run.ID, run.ID)
+}
@@ -0,0 +1,63 @@
+package loop
+
+import (
+	"fmt"
+
+	"github.com/wltechblog/ralphy/internal/agent"
+	"github.com/wltechblog/ralphy/internal/opencode"
+	"github.com/wltechblog/ralphy/internal/pricing"
+	"github.com/wltechblog/ralphy/internal/state"
+	"github.com/wltechblog/ralphy/internal/tools"
+)
+
+// iterationUsage records the tokens the agent reported on record and prices
+// them: from the price table when it knows the model, otherwise with the
+// cost the agent reported itself. Agents that report no usage leave record
+// untouched.
+func iterationUsage(record *state.IterationHistory, model string, result *agent.Result, prices pricing.Table) {
+	if result == nil || (result.Usage.Total() == 0 && result.Cost == 0) {
+		return
+	}
+	usage := stateUsage(result.Usage)
+	record.Tokens = &usage
+	record.CostUSD, record.CostSource = priceUsage(usage, result.Cost, model, prices)
+}
+
+// priceUsage prices usage from the table, falling back to the cost the
+// agent reported. The source is empty when neither is known.
+func priceUsage(usage state.TokenUsage, agentCost float64, model string, prices pricing.Table) (float64, string) {
+	if price, ok := prices.Lookup(model); ok {
+		return price.Cost(usage), state.CostSourcePrices
+	}
+	if agentCost > 0 {
+		return agentCost, state.CostSourceAgent
+	}
+	return 0, ""
+}
+
+func stateUsage(u opencode.TokenUsage) state.TokenUsage {
+	return state.TokenUsage{
+		Input:      u.Input,
+		Output:     u.Output,
+		Reasoning:  u.Reasoning,
+		CacheRead:  u.CacheRead,
+		CacheWrite: u.CacheWrite,
+	}
+}
+
+func printIterationUsage(record *state.IterationHistory, h *state.RalphHistory) {
+	if record.Tokens == nil {
+		return
+	}
+	fmt.Printf("Tokens:    %s\n", record.Tokens.Summary())
+	if record.CostSource != "" {
+		fmt.Printf("Cost:      %s (run total %s)\n", tools.FormatCost(record.CostUSD), tools.FormatCost(h.TotalCostUSD))
+	}
+}
+
+// printTotalCost adds the run's cost to a summary box.
+func printTotalCost(h *state.RalphHistory) {
+	if h.TotalCostUSD > 0 {
+		fmt.Printf("║  Total cost: %s\n", tools.FormatCost(h.TotalCostUSD))
+	}
+}
@@ -0,0 +1,91 @@
+package loop
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+var eventWarning sync.Once
+
+// emit appends an event of the loop's run to ralph-events.jsonl. A failing
+// event log is reported once and otherwise does not disturb the loop.
+func emit(s *state.RalphState, e *state.Event) {
+	e.RunID = s.RunID
+	if err := state.AppendEvent(e); err != nil {
+		eventWarning.Do(func() {
+			fmt.Printf("⚠️  Failed to write event log: %v\n", err)
+		})
+	}
+}
+
+func emitIterationFinished(s *state.RalphState, h *state.RalphHistory, record *state.IterationHistory) {
+	struggle := h.StruggleIndicators
+	emit(s, &state.Event{
+		Type:      state.EventIterationFinished,
+		Iteration: record.Iteration,
+		Record:    record,
+		Struggle:  &struggle,
+	})
+}
+
+func emitCheckResults(s *state.RalphState, kind string, results []state.CheckResult) {
+	for i := range results {
+		emit(s, &state.Event{
+			Type:      state.EventCheckResult,
+			Iteration: s.Iteration,
+			Kind:      kind,
+			Check:     &results[i],
+		})
+	}
+}
+
+// toolRecorder emits a tool_call event for every tool call the backend
+// reports while it runs. Backends that buffer their output only report
+// totals, which flush turns into events at the end of the iteration.
+type toolRecorder struct {
+	mu     sync.Mutex
+	s      *state.RalphState
+	counts map[string]int
+}
+
+func newToolRecorder(s *state.RalphState) *toolRecorder {
+	return &toolRecorder{s: s, counts: map[string]int{}}
+}
+
+func (r *toolRecorder) record(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[name]++
+	emit(r.s, &state.Event{Type: state.EventToolCall, Iteration: r.s.Iteration, Tool: name, Count: 1})
+}
+
+// snapshot returns the tool calls recorded so far.
+func (r *toolRecorder) snapshot() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := make(map[string]int, len(r.counts))
+	for name, count := range r.counts {
+		counts[name] = count
+	}
+	return counts
+}
+
+func (r *toolRecorder) flush(totals map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var names []string
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if missing := totals[name] - r.counts[name]; missing > 0 {
+			r.counts[name] += missing
+			emit(r.s, &state.Event{Type: state.EventToolCall, Iteration: r.s.Iteration, Tool: name, Count: missing})
+		}
+	}
+}
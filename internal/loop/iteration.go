@@ -1,11 +1,14 @@
 package loop
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/wltechblog/ralphy/internal/agent"
 	"github.com/wltechblog/ralphy/internal/git"
 	"github.com/wltechblog/ralphy/internal/opencode"
 	"github.com/wltechblog/ralphy/internal/state"
@@ -15,14 +18,17 @@ import (
 type IterationResult struct {
 	ExitCode               int
 	CompletionDetected     bool
+	CompletionRejected     bool
 	TaskCompletionDetected bool
 	DurationMs             int64
 	ToolCounts             map[string]int
 	FilesModified          []string
 	Errors                 []string
+	Aborted                bool
+	TimedOut               bool
 }
 
-func RunIteration(s *state.RalphState, h *state.RalphHistory, autoCommit bool, timeout time.Duration, verbose bool, verboseTools bool, allowAllPermissions bool) (*IterationResult, error) {
+func RunIteration(s *state.RalphState, h *state.RalphHistory, backend agent.Backend, opts *LoopOptions, cancel <-chan struct{}, budget *budgetGuard) (*IterationResult, error) {
 	fmt.Printf("\n🔄 Iteration %d", s.Iteration)
 	if s.MaxIterations > 0 {
 		fmt.Printf(" / %d", s.MaxIterations)
@@ -37,78 +43,185 @@ func RunIteration(s *state.RalphState, h *state.RalphHistory, autoCommit bool, t
 		snapshotBefore = &git.FileSnapshot{Files: map[string]string{}}
 	}
 
-	fullPrompt := opencode.BuildPrompt(s, contextAtStart)
+	var lastChecks []state.CheckResult
+	if len(h.Iterations) > 0 {
+		lastChecks = h.Iterations[len(h.Iterations)-1].Checks
+	}
+	fullPrompt := opencode.BuildPrompt(s, contextAtStart, lastChecks)
 	iterationStart := time.Now()
+	budget.startIteration(s.Model)
+	emit(s, &state.Event{Type: state.EventIterationStarted, Iteration: s.Iteration})
+	toolEvents := newToolRecorder(s)
 
 	var result *IterationResult
 	var exitCode int
 
-	opencodeResult, code, err := opencode.RunOpenCode(&opencode.RunOpenCodeOptions{
+	agentCancel, timedOut, stopDeadline := iterationDeadline(cancel, opts.IterationTimeout)
+	agentResult, err := backend.Run(&agent.Options{
 		Prompt:              fullPrompt,
 		Model:               s.Model,
-		StreamOutput:        true,
-		VerboseTools:        verboseTools,
-		DisablePlugins:      false,
-		AllowAllPermissions: allowAllPermissions,
+		StreamOutput:        opts.StreamOutput,
+		VerboseTools:        opts.VerboseTools,
+		DisablePlugins:      opts.DisablePlugins,
+		AllowAllPermissions: opts.AllowAllPermissions,
 		IterationStart:      iterationStart,
-		Verbose:             verbose,
-		Timeout:             timeout,
+		Verbose:             opts.Verbose,
+		Timeout:             opts.Timeout,
+		Heartbeat: func() {
+			state.Heartbeat(s)
+			budget.checkLive()
+		},
+		OnTool:  toolEvents.record,
+		OnUsage: budget.onUsage,
+		Cancel:  agentCancel,
 	})
+	stopDeadline()
+
+	// An iteration that ran out of time, or whose agent went quiet for too
+	// long, still counts: what the agent did before it was killed is
+	// checked, recorded and committed as usual.
+	var timeoutError, timeoutContext string
+	switch {
+	case errors.Is(err, opencode.ErrCancelled) && timedOut():
+		fmt.Printf("\n⏱️  Iteration %d hit the iteration timeout (%v); the agent was stopped.\n", s.Iteration, opts.IterationTimeout)
+		timeoutError = fmt.Sprintf("iteration timeout (%v) reached", opts.IterationTimeout)
+		timeoutContext = fmt.Sprintf("Iteration %d was stopped after running for %v. Work in smaller steps and keep the work done so far.", s.Iteration, opts.IterationTimeout)
+	case errors.Is(err, opencode.ErrInactivityTimeout):
+		fmt.Printf("\n⏳ Iteration %d timed out after %v of inactivity.\n", s.Iteration, opts.Timeout)
+		timeoutError = err.Error()
+		timeoutContext = fmt.Sprintf("Iteration %d timed out after %v of inactivity. Please try again or take a different approach.", s.Iteration, opts.Timeout)
+	}
+	iterationTimedOut := timeoutError != ""
+	if iterationTimedOut {
+		// The output read before the agent was stopped goes into the
+		// transcript and is searched for errors and the promises.
+		output := &agent.Result{}
+		if agentResult != nil {
+			output = agentResult
+		}
+		partial := budget.partialUsage()
+		agentResult = &agent.Result{
+			StdoutText: output.StdoutText,
+			StderrText: output.StderrText,
+			Events:     output.Events,
+			ToolCounts: toolEvents.snapshot(),
+			ExitCode:   -1,
+			Usage:      partial.Usage,
+			Cost:       partial.Cost,
+		}
+		err = nil
+	}
+	if opts.Transcripts {
+		saveTranscript(s.Iteration, fullPrompt, agentResult, opts.CompressTranscripts)
+	}
 
-	if err != nil {
-		if strings.Contains(err.Error(), "timeout") {
-			fmt.Printf("\n⏳ Iteration %d timed out after %v of inactivity.\n", s.Iteration, timeout)
-			state.SaveContext(fmt.Sprintf("Iteration %d timed out after %v of inactivity. Please try again or take a different approach.", s.Iteration, timeout))
-			
-			// Return a partial result to keep history happy, but marked as failure
-			return &IterationResult{
-				ExitCode:           -1,
-				CompletionDetected: false,
-				DurationMs:         time.Since(iterationStart).Milliseconds(),
-				ToolCounts:         map[string]int{},
-				FilesModified:      []string{},
-				Errors:             []string{err.Error()},
-			}, nil
+	if errors.Is(err, opencode.ErrCancelled) {
+		fmt.Printf("\n🛑 Iteration %d interrupted.\n", s.Iteration)
+		durationMs := time.Since(iterationStart).Milliseconds()
+		record := &state.IterationHistory{
+			Iteration:     s.Iteration,
+			StartedAt:     iterationStart.Format(time.RFC3339),
+			EndedAt:       time.Now().Format(time.RFC3339),
+			DurationMs:    durationMs,
+			ToolsUsed:     map[string]int{},
+			FilesModified: []string{},
+			ExitCode:      -1,
+			Model:         s.Model,
+			Errors:        []string{"interrupted"},
 		}
-		return nil, fmt.Errorf("failed to run opencode: %w", err)
+		iterationUsage(record, s.Model, budget.partialUsage(), opts.Prices)
+		state.AddIteration(h, record)
+		state.SaveHistory(h)
+		emitIterationFinished(s, h, record)
+		if opts.AutoCommit {
+			autoCommit(s, fmt.Sprintf("Ralph iteration %d: interrupted", s.Iteration))
+		}
+		return &IterationResult{
+			ExitCode:      -1,
+			DurationMs:    durationMs,
+			ToolCounts:    map[string]int{},
+			FilesModified: []string{},
+			Errors:        []string{"interrupted"},
+			Aborted:       true,
+		}, nil
+	}
+
+	if err != nil {
+		return nil, classifyRunError(fmt.Errorf("failed to run %s: %w", backend.Name(), err))
+	}
+	if failure := classifyResult(agentResult); failure != nil && !iterationTimedOut {
+		return nil, failure
 	}
 
-	exitCode = code
+	exitCode = agentResult.ExitCode
 	iterationDuration := time.Since(iterationStart)
+	toolEvents.flush(agentResult.ToolCounts)
 
 	snapshotAfter, _ := git.CaptureFileSnapshot()
-	filesModified := git.GetModifiedFilesSinceSnapshot(snapshotBefore, snapshotAfter)
+	filesModified := withoutStateFiles(git.GetModifiedFilesSinceSnapshot(snapshotBefore, snapshotAfter))
 
-	combinedOutput := opencodeResult.StdoutText + "\n" + opencodeResult.StderrText
+	combinedOutput := agentResult.StdoutText + "\n" + agentResult.StderrText
 	// Completion promise should only be in the AI's response (stdout)
-	completionDetected := CheckCompletion(opencodeResult.StdoutText, s.CompletionPromise)
-	taskCompletionDetected := CheckCompletion(opencodeResult.StdoutText, s.TaskPromise)
+	completionDetected := CheckCompletion(agentResult.StdoutText, s.CompletionPromise)
+	taskCompletionDetected := CheckCompletion(agentResult.StdoutText, s.TaskPromise)
+	completionClaimed := completionDetected
 
 	errors := tools.ExtractErrors(combinedOutput)
+	if iterationTimedOut {
+		errors = append(errors, timeoutError)
+	}
+
+	var checks []state.CheckResult
+	if len(opts.Checks) > 0 {
+		checks = runIterationChecks(opts.Checks, cancel)
+		emitCheckResults(s, "check", checks)
+	}
+
+	var verification []state.CheckResult
+	completionRejected := false
+	if completionDetected && len(opts.Verify) > 0 {
+		var passed bool
+		verification, passed = runVerification(opts.Verify, cancel)
+		emitCheckResults(s, "verify", verification)
+		if !passed {
+			completionDetected = false
+			completionRejected = true
+		}
+	}
 
 	result = &IterationResult{
 		ExitCode:               exitCode,
 		CompletionDetected:     completionDetected,
+		CompletionRejected:     completionRejected,
 		TaskCompletionDetected: taskCompletionDetected,
 		DurationMs:             iterationDuration.Milliseconds(),
-		ToolCounts:             opencodeResult.ToolCounts,
+		ToolCounts:             agentResult.ToolCounts,
 		FilesModified:          filesModified,
 		Errors:                 errors,
+		TimedOut:               iterationTimedOut,
 	}
 
-	printIterationSummary(s.Iteration, iterationDuration.Milliseconds(), opencodeResult.ToolCounts, exitCode, completionDetected, taskCompletionDetected, s.TaskPromise)
-
-	state.AddIteration(h, &state.IterationHistory{
+	record := &state.IterationHistory{
 		Iteration:          s.Iteration,
 		StartedAt:          iterationStart.Format(time.RFC3339),
 		EndedAt:            time.Now().Format(time.RFC3339),
 		DurationMs:         iterationDuration.Milliseconds(),
-		ToolsUsed:          opencodeResult.ToolCounts,
+		ToolsUsed:          agentResult.ToolCounts,
 		FilesModified:      filesModified,
 		ExitCode:           exitCode,
+		Model:              s.Model,
 		CompletionDetected: completionDetected,
+		CompletionRejected: completionRejected,
+		TimedOut:           iterationTimedOut,
+		Verification:       verification,
+		Checks:             checks,
 		Errors:             errors,
-	})
+	}
+	iterationUsage(record, s.Model, agentResult, opts.Prices)
+	state.AddIteration(h, record)
+
+	printIterationSummary(s.Iteration, iterationDuration.Milliseconds(), agentResult.ToolCounts, exitCode, completionDetected, taskCompletionDetected, s.TaskPromise)
+	printIterationUsage(record, h)
 
 	state.UpdateStruggleIndicators(h, &state.IterationHistory{
 		Iteration:     s.Iteration,
@@ -118,6 +231,23 @@ func RunIteration(s *state.RalphState, h *state.RalphHistory, autoCommit bool, t
 	})
 
 	state.SaveHistory(h)
+	emitIterationFinished(s, h, record)
+
+	if completionClaimed {
+		emit(s, &state.Event{
+			Type:      state.EventPromiseDetected,
+			Iteration: s.Iteration,
+			Promise:   "completion",
+			Text:      s.CompletionPromise,
+			Rejected:  completionRejected,
+		})
+	}
+	if taskCompletionDetected {
+		emit(s, &state.Event{Type: state.EventPromiseDetected, Iteration: s.Iteration, Promise: "task", Text: s.TaskPromise})
+	}
+	if contextAtStart != "" {
+		emit(s, &state.Event{Type: state.EventContextConsumed, Iteration: s.Iteration, Context: contextAtStart})
+	}
 
 	if s.Iteration > 2 && (h.StruggleIndicators.NoProgressIterations >= 3 || h.StruggleIndicators.ShortIterations >= 3) {
 		fmt.Println("\n⚠️  Potential struggle detected:")
@@ -127,7 +257,7 @@ func RunIteration(s *state.RalphState, h *state.RalphHistory, autoCommit bool, t
 		if h.StruggleIndicators.ShortIterations >= 3 {
 			fmt.Printf("   - %d very short iterations\n", h.StruggleIndicators.ShortIterations)
 		}
-		fmt.Println("   💡 Tip: Use 'ralphy --add-context \"hint\"' in another terminal to guide the agent")
+		fmt.Println("   💡 Tip: Use 'ralphy context add \"hint\"' in another terminal to guide the agent")
 	}
 
 	if DetectPlaceholderPluginError(combinedOutput) {
@@ -136,22 +266,23 @@ func RunIteration(s *state.RalphState, h *state.RalphHistory, autoCommit bool, t
 		return nil, fmt.Errorf("placeholder plugin detected")
 	}
 
-	if exitCode != 0 {
-		fmt.Printf("\n⚠️  OpenCode exited with code %d. Continuing to next iteration.\n", exitCode)
+	if exitCode != 0 && !iterationTimedOut {
+		fmt.Printf("\n⚠️  %s exited with code %d. Continuing to next iteration.\n", backend.Name(), exitCode)
 	}
 
-	if autoCommit {
+	if opts.AutoCommit {
 		message := fmt.Sprintf("Ralph iteration %d: work in progress", s.Iteration)
+		if iterationTimedOut {
+			message = fmt.Sprintf("Ralph iteration %d: timed out", s.Iteration)
+		}
 		if completionDetected {
 			message = fmt.Sprintf("Ralph iteration %d: task completed", s.Iteration)
 		}
+		autoCommit(s, message)
+	}
 
-		committed, err := git.AutoCommit(message)
-		if err != nil {
-			fmt.Printf("⚠️  Git auto-commit failed: %v\n", err)
-		} else if committed {
-			fmt.Println("📝 Auto-committed changes")
-		}
+	if completionRejected {
+		fmt.Printf("\n🚫 Completion promise rejected: verification failed. Continuing to next iteration.\n")
 	}
 
 	if taskCompletionDetected && !completionDetected {
@@ -163,16 +294,20 @@ func RunIteration(s *state.RalphState, h *state.RalphHistory, autoCommit bool, t
 		fmt.Printf("║  ✅ Completion promise detected: <promise>%s</promise>\n", s.CompletionPromise)
 		fmt.Printf("║  Task completed in %d iteration(s)\n", s.Iteration)
 		fmt.Printf("║  Total time: %s\n", tools.FormatDurationLong(h.TotalDurationMs))
+		printTotalCost(h)
 		fmt.Printf("╚══════════════════════════════════════════════════════════════════╝\n")
-		state.ClearState()
-		state.ClearHistory()
-		state.ClearContext()
 		return result, nil
 	}
 
 	if contextAtStart != "" {
 		fmt.Println("📝 Context was consumed this iteration")
-		state.ClearContext()
+		state.ConsumeContext(contextAtStart)
+	}
+	if completionRejected {
+		state.SaveContext(verificationContext(s.CompletionPromise, verification))
+	}
+	if iterationTimedOut {
+		state.SaveContext(timeoutContext)
 	}
 
 	s.Iteration++
@@ -183,6 +318,61 @@ func RunIteration(s *state.RalphState, h *state.RalphHistory, autoCommit bool, t
 	return result, nil
 }
 
+// withoutStateFiles drops the loop's own files, such as the event log and
+// transcripts, which change every iteration and are not progress.
+func withoutStateFiles(files []string) []string {
+	base, err := state.GetBaseStateDir()
+	if err != nil {
+		return files
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return files
+	}
+	rel, err := filepath.Rel(cwd, base)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return files
+	}
+	prefix := filepath.ToSlash(rel) + "/"
+
+	kept := []string{}
+	for _, file := range files {
+		name, ok := strings.CutPrefix(file, prefix)
+		if ok && (strings.HasPrefix(name, "ralph-") || strings.HasPrefix(name, "loops/")) {
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
+
+func autoCommit(s *state.RalphState, message string) {
+	committed, err := git.AutoCommit(message)
+	if err != nil {
+		fmt.Printf("⚠️  Git auto-commit failed: %v\n", err)
+	} else if committed {
+		fmt.Println("📝 Auto-committed changes")
+		commit, _ := git.HeadCommit()
+		emit(s, &state.Event{Type: state.EventCommitCreated, Iteration: s.Iteration, Commit: commit, Message: message})
+	}
+}
+
+// saveTranscript records the prompt and agent output of an iteration. When
+// the agent failed or was interrupted, result is nil and only the prompt is
+// kept.
+func saveTranscript(iteration int, prompt string, result *agent.Result, compress bool) {
+	t := &state.Transcript{Iteration: iteration, Prompt: prompt}
+	if result != nil {
+		t.Stdout = result.StdoutText
+		t.Stderr = result.StderrText
+		t.StdoutPlain = tools.StripAnsi(result.StdoutText)
+		t.StderrPlain = tools.StripAnsi(result.StderrText)
+	}
+	if err := state.SaveTranscript(t, compress); err != nil {
+		fmt.Printf("⚠️  Failed to save transcript: %v\n", err)
+	}
+}
+
 func printIterationSummary(iteration int, elapsedMs int64, toolCounts map[string]int, exitCode int, completionDetected bool, taskCompletionDetected bool, taskPromise string) {
 	fmt.Println("\nIteration Summary")
 	fmt.Println("────────────────────────────────────────────────────────────────────")
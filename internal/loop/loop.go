@@ -1,37 +1,143 @@
 package loop
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/wltechblog/ralphy/internal/agent"
+	"github.com/wltechblog/ralphy/internal/pricing"
 	"github.com/wltechblog/ralphy/internal/state"
 )
 
 type LoopOptions struct {
-	Prompt              string
-	PromptSource        string
-	MaxIterations       int
-	CompletionPromise   string
-	TaskPromise         string
-	Model               string
-	StreamOutput        bool
-	VerboseTools        bool
-	DisablePlugins      bool
-	AutoCommit          bool
+	Prompt            string
+	PromptSource      string
+	MaxIterations     int
+	CompletionPromise string
+	TaskPromise       string
+	Model             string
+	Agent             string
+	AgentCommand      string
+	PromptVia         string
+	ToolPattern       string
+	JSONEvents        bool
+	ServerURL         string
+	ReuseSession      bool
+	StreamOutput      bool
+	VerboseTools      bool
+	DisablePlugins    bool
+	AutoCommit        bool
+	// Branch is the branch iterations are committed to, AutoBranch for one
+	// named after the run, or "" for the current branch. Merge is the
+	// git.MergeSquash or git.MergeCommit to do on completion.
+	Branch              string
+	Merge               string
 	AllowAllPermissions bool
 	Verbose             bool
 	Timeout             time.Duration
+	IterationTimeout    time.Duration
+	// RetryDelay and RetryMaxDelay bound the backoff between attempts after
+	// an agent failure; MaxFailures consecutive failures pause the loop.
+	RetryDelay    time.Duration
+	RetryMaxDelay time.Duration
+	MaxFailures   int
+	// ModelChain lists models from cheapest to strongest. The loop starts on
+	// the first, escalates after EscalateAfter struggling iterations or an
+	// agent failure, and steps back down after DeescalateAfter iterations
+	// that made progress.
+	ModelChain          []string
+	EscalateAfter       int
+	DeescalateAfter     int
+	Verify              []string
+	Checks              []string
+	Transcripts         bool
+	CompressTranscripts bool
+	Resume              bool
+	Takeover            bool
+	// Prices prices the tokens the agent reports; nil uses the built-in table.
+	Prices pricing.Table
+	Budget state.Budget
 }
 
-func RunLoop(opts *LoopOptions) error {
+var ErrLoopActive = errors.New("a Ralph loop is already active")
+
+func RunLoop(opts *LoopOptions) (*LoopResult, error) {
 	existingState, err := state.LoadState()
-	if err == nil && existingState.Active {
-		return fmt.Errorf("a Ralph loop is already active (iteration %d)\nStarted at: %s\nTo cancel it, press Ctrl+C in its terminal or delete %s",
-			existingState.Iteration, existingState.StartedAt, ".opencode/ralph-loop.state.json")
+	if err != nil && !errors.Is(err, state.ErrStateNotFound) {
+		return nil, fmt.Errorf("%w\nInspect and repair it with: ralphy doctor", err)
+	}
+	if opts.Resume && err != nil {
+		return nil, fmt.Errorf("no loop to resume: %w", err)
+	}
+
+	switch state.CheckOwner(existingState) {
+	case state.OwnerRunning:
+		// Even with --takeover: a loop whose owner is alive, or on another
+		// host still sends heartbeats, would end up driven by two processes.
+		hint := "To cancel it, press Ctrl+C in its terminal"
+		if existingState.Hostname != currentHostname() {
+			hint = fmt.Sprintf("%s, or take it over with --takeover once it has sent no heartbeat for %s", hint, formatDurationLong(state.StaleHeartbeatAfter.Milliseconds()))
+		}
+		return nil, fmt.Errorf("%w (iteration %d)\nStarted at: %s\nOwner: %s\n%s",
+			ErrLoopActive, existingState.Iteration, existingState.StartedAt, describeOwner(existingState), hint)
+	case state.OwnerStale:
+		if !opts.Resume && !opts.Takeover && !confirmTakeover(existingState) {
+			return nil, fmt.Errorf("%w but stale (iteration %d, owner %s)\nContinue it with: ralphy --resume\nOr replace it with a new loop: ralphy --takeover \"<prompt>\"",
+				ErrLoopActive, existingState.Iteration, describeOwner(existingState))
+		}
+	}
+
+	if opts.Prices == nil {
+		opts.Prices = pricing.Builtin()
+	}
+	// Unset retry delays get their defaults; the values given are checked
+	// where the options are parsed.
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = DefaultRetryDelay
+	}
+	if opts.RetryMaxDelay == 0 {
+		opts.RetryMaxDelay = max(DefaultRetryMaxDelay, opts.RetryDelay)
+	}
+
+	lock, err := state.AcquireLoopLock()
+	if err != nil {
+		if errors.Is(err, state.ErrLockHeld) {
+			return nil, fmt.Errorf("%w (another ralphy process holds the loop lock)", ErrLoopActive)
+		}
+		return nil, fmt.Errorf("failed to lock loop state: %w", err)
+	}
+	defer lock.Unlock()
+	state.ClearControl()
+
+	if opts.Resume {
+		applySavedState(opts, existingState)
+	} else if existingState != nil && existingState.Active {
+		archiveReplaced(existingState)
+	}
+	if len(opts.ModelChain) > 0 && !slices.Contains(opts.ModelChain, opts.Model) {
+		opts.Model = opts.ModelChain[0]
+	}
+
+	backend, err := agent.New(opts.Agent, &agent.Config{
+		Command:      opts.AgentCommand,
+		PromptVia:    opts.PromptVia,
+		ToolPattern:  opts.ToolPattern,
+		JSONEvents:   opts.JSONEvents,
+		ServerURL:    opts.ServerURL,
+		ReuseSession: opts.ReuseSession,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := backend.(io.Closer); ok {
+		defer closer.Close()
 	}
 
 	fmt.Println(`
@@ -40,21 +146,65 @@ func RunLoop(opts *LoopOptions) error {
 ║            Iterative AI Development with OpenCode                ║
 ╚══════════════════════════════════════════════════════════════════╝`)
 
-	s := &state.RalphState{
-		Active:            true,
-		Iteration:         1,
-		MaxIterations:     opts.MaxIterations,
-		CompletionPromise: opts.CompletionPromise,
-		TaskPromise:       opts.TaskPromise,
-		Prompt:            opts.Prompt,
-		StartedAt:         time.Now().Format(time.RFC3339),
-		Model:             opts.Model,
+	var s *state.RalphState
+	if opts.Resume {
+		s = existingState
+		s.Active = true
+		s.MaxIterations = opts.MaxIterations
+		s.Model = opts.Model
+		s.ModelChain = opts.ModelChain
+		s.Agent = backend.Name()
+		s.Verify = opts.Verify
+		s.Checks = opts.Checks
+		s.StopReason = ""
+		s.StopDetail = ""
+		fmt.Printf("Resuming loop at iteration %d (started %s)\n", s.Iteration, s.StartedAt)
+	} else {
+		startedAt := time.Now()
+		s = &state.RalphState{
+			RunID:             state.NewRunID(startedAt),
+			Name:              state.LoopName(),
+			Active:            true,
+			Iteration:         1,
+			MaxIterations:     opts.MaxIterations,
+			CompletionPromise: opts.CompletionPromise,
+			TaskPromise:       opts.TaskPromise,
+			Prompt:            opts.Prompt,
+			StartedAt:         startedAt.Format(time.RFC3339),
+			Model:             opts.Model,
+			ModelChain:        opts.ModelChain,
+			Agent:             backend.Name(),
+			Verify:            opts.Verify,
+			Checks:            opts.Checks,
+		}
+	}
+	s.Budget = nil
+	if opts.Budget.IsSet() {
+		s.Budget = &opts.Budget
+	}
+	state.EnsureRunID(s)
+	if err := setupBranch(s, opts); err != nil {
+		return nil, err
 	}
 
-	state.SaveState(s)
-
-	h, err := state.LoadHistory()
-	if err != nil {
+	// A new loop starts with an empty history and no transcripts; the
+	// previous loop's, if it finished, live on in its run archive.
+	var h *state.RalphHistory
+	if opts.Resume {
+		h, err = state.LoadHistory()
+		// A lost or corrupt history file can be rebuilt from the event
+		// log, which has every finished iteration.
+		if rebuilt, rebuildErr := state.RebuildHistory(s.RunID); rebuildErr == nil && len(rebuilt.Iterations) > 0 && (err != nil || len(rebuilt.Iterations) > len(h.Iterations)) {
+			fmt.Printf("Recovered %d iteration(s) of history from the event log\n", len(rebuilt.Iterations))
+			h, err = rebuilt, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w\nInspect and repair it with: ralphy doctor", err)
+		}
+	} else {
+		state.ClearTranscripts()
+	}
+	if h == nil {
 		h = &state.RalphHistory{
 			Iterations:      []state.IterationHistory{},
 			TotalDurationMs: 0,
@@ -65,6 +215,19 @@ func RunLoop(opts *LoopOptions) error {
 			},
 		}
 	}
+
+	state.ClaimOwnership(s)
+
+	state.SaveState(s)
+	emit(s, &state.Event{
+		Type:          state.EventLoopStarted,
+		Iteration:     s.Iteration,
+		Resumed:       opts.Resume,
+		Agent:         s.Agent,
+		Model:         s.Model,
+		MaxIterations: s.MaxIterations,
+	})
+
 	state.SaveHistory(h)
 
 	promptPreview := opts.Prompt
@@ -72,6 +235,9 @@ func RunLoop(opts *LoopOptions) error {
 		promptPreview = promptPreview[:80] + "..."
 	}
 
+	if s.Name != "" {
+		fmt.Printf("Loop: %s\n", s.Name)
+	}
 	if opts.PromptSource != "" {
 		fmt.Printf("Task: %s\n", opts.PromptSource)
 		fmt.Printf("Preview: %s\n", promptPreview)
@@ -85,7 +251,15 @@ func RunLoop(opts *LoopOptions) error {
 		maxIter = fmt.Sprintf("%d", opts.MaxIterations)
 	}
 	fmt.Printf("Max iterations: %s\n", maxIter)
-	if opts.Model != "" {
+	fmt.Printf("Agent: %s\n", backend.Name())
+	if s.Branch != "" && s.BaseBranch != "" {
+		fmt.Printf("Branch: %s (from %s)\n", s.Branch, s.BaseBranch)
+	} else if s.Branch != "" {
+		fmt.Printf("Branch: %s\n", s.Branch)
+	}
+	if len(opts.ModelChain) > 0 {
+		fmt.Printf("Model chain: %s (starting with %s)\n", strings.Join(opts.ModelChain, " → "), opts.Model)
+	} else if opts.Model != "" {
 		fmt.Printf("Model: %s\n", opts.Model)
 	}
 	if opts.DisablePlugins {
@@ -97,38 +271,100 @@ func RunLoop(opts *LoopOptions) error {
 	if opts.Timeout > 0 {
 		fmt.Printf("Timeout: %v\n", opts.Timeout)
 	}
+	if opts.IterationTimeout > 0 {
+		fmt.Printf("Iteration timeout: %v\n", opts.IterationTimeout)
+	}
+	if s.Budget != nil {
+		fmt.Printf("Budget: %s\n", s.Budget.Summary())
+	}
+	for _, command := range opts.Checks {
+		fmt.Printf("Check: %s\n", command)
+	}
+	for _, command := range opts.Verify {
+		fmt.Printf("Verify: %s\n", command)
+	}
 
 	fmt.Println("")
 	fmt.Println("Starting loop... (Ctrl+C to stop)")
 	fmt.Println(strings.Repeat("═", 68))
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT)
+	sd := newShutdown()
+	budget := newBudgetGuard(opts.Budget, s, h, opts.Prices, sd)
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
 
 	go func() {
-		<-sigChan
-		fmt.Println("\nGracefully stopping Ralph loop...")
-		state.ClearState()
-		fmt.Println("Loop cancelled.")
-		os.Exit(0)
+		sig := <-sigChan
+		fmt.Printf("\n🛑 Received %v, stopping the agent and saving progress... (repeat to force exit)\n", sig)
+		sd.trigger(StopSignal, sig)
+
+		sig = <-sigChan
+		fmt.Println("Forced exit.")
+		emit(s, &state.Event{Type: state.EventLoopFinished, Outcome: StopSignal, Iterations: len(h.Iterations)})
+		if closer, ok := backend.(io.Closer); ok {
+			closer.Close()
+		}
+		state.SaveHistory(h)
+		os.Exit((&LoopResult{StopReason: StopSignal, Signal: sig}).ExitCode())
 	}()
 
+	controlDone := make(chan struct{})
+	defer close(controlDone)
+	go watchControl(sd, controlDone)
+
+	failures := 0
 	for {
+		if reason, sig, ok := sd.requested(); ok {
+			if reason == StopBudget {
+				return finishOverBudget(s, h, budget), nil
+			}
+			return finishEarly(s, h, reason, sig), nil
+		}
+		if req := waitWhilePaused(s, sd); req != nil {
+			switch req.Action {
+			case state.ControlStop:
+				return finishEarly(s, h, StopRequested, nil), nil
+			case state.ControlAbort:
+				sd.trigger(StopAborted, nil)
+			}
+			continue
+		}
+
 		if opts.MaxIterations > 0 && s.Iteration > opts.MaxIterations {
 			fmt.Println("\n╔══════════════════════════════════════════════════════════════════╗")
 			fmt.Printf("║  Max iterations (%d) reached. Loop stopped.\n", opts.MaxIterations)
 			fmt.Printf("║  Total time: %s\n", formatDurationLong(h.TotalDurationMs))
+			printTotalCost(h)
 			fmt.Println("╚══════════════════════════════════════════════════════════════════╝")
+			s.Active = false
+			archiveRun(s, h, StopMaxIterations)
 			state.ClearState()
-			return nil
+			state.ClearHistory()
+			state.ClearTranscripts()
+			return &LoopResult{StopReason: StopMaxIterations, Iterations: len(h.Iterations)}, nil
 		}
 
-		result, err := RunIteration(s, h, opts.AutoCommit, opts.Timeout, opts.Verbose, opts.VerboseTools, opts.AllowAllPermissions)
+		if _, exceeded := budget.checkHistory(); exceeded {
+			return finishOverBudget(s, h, budget), nil
+		}
+
+		state.Heartbeat(s)
+		result, err := RunIteration(s, h, backend, opts, sd.cancel, budget)
 		if err == nil {
-			h.TotalDurationMs += result.DurationMs
 			state.SaveHistory(h)
 		}
 
+		var failure *agentFailure
+		if errors.As(err, &failure) {
+			failures++
+			if handleAgentFailure(s, h, failure, failures, opts, sd) {
+				failures = 0
+			}
+			continue
+		}
+		failures = 0
+
 		if err != nil {
 			fmt.Printf("\n❌ Error in iteration %d: %v\n", s.Iteration, err)
 			fmt.Println("Continuing to next iteration...")
@@ -142,12 +378,13 @@ func RunLoop(opts *LoopOptions) error {
 				ToolsUsed:          map[string]int{},
 				FilesModified:      []string{},
 				ExitCode:           -1,
+				Model:              s.Model,
 				CompletionDetected: false,
 				Errors:             []string{fmt.Sprintf("%v", err)},
 			}
 			state.AddIteration(h, errorRecord)
-			h.TotalDurationMs += iterationDuration.Milliseconds()
 			state.SaveHistory(h)
+			emitIterationFinished(s, h, errorRecord)
 
 			s.Iteration++
 			state.SaveState(s)
@@ -156,12 +393,98 @@ func RunLoop(opts *LoopOptions) error {
 			continue
 		}
 
+		if !result.CompletionDetected && !result.Aborted {
+			adjustModel(s, h, opts)
+		}
+
 		if result.CompletionDetected {
-			return nil
+			s.Active = false
+			finishBranch(s)
+			archiveRun(s, h, StopCompleted)
+			state.ClearState()
+			state.ClearHistory()
+			state.ClearContext()
+			state.ClearTranscripts()
+			return &LoopResult{StopReason: StopCompleted, Iterations: len(h.Iterations)}, nil
+		}
+	}
+}
+
+// applySavedState fills opts from the state of an interrupted loop. The
+// prompt and promises always come from the saved state; the model, iteration
+// limit and budget only when they were not given on the command line.
+func applySavedState(opts *LoopOptions, s *state.RalphState) {
+	opts.Prompt = s.Prompt
+	opts.PromptSource = ""
+	opts.CompletionPromise = s.CompletionPromise
+	opts.TaskPromise = s.TaskPromise
+	if opts.Model == "" {
+		opts.Model = s.Model
+	}
+	if len(opts.ModelChain) == 0 {
+		opts.ModelChain = s.ModelChain
+	}
+	if opts.Merge == "" {
+		opts.Merge = s.Merge
+	}
+	if opts.MaxIterations == 0 {
+		opts.MaxIterations = s.MaxIterations
+	}
+	if opts.Agent == "" && s.Agent != "" {
+		opts.Agent = s.Agent
+	}
+	if len(opts.Verify) == 0 {
+		opts.Verify = s.Verify
+	}
+	if len(opts.Checks) == 0 {
+		opts.Checks = s.Checks
+	}
+	if saved := s.Budget; saved != nil {
+		if opts.Budget.MaxDurationMs == 0 {
+			opts.Budget.MaxDurationMs = saved.MaxDurationMs
+		}
+		if opts.Budget.MaxCostUSD == 0 {
+			opts.Budget.MaxCostUSD = saved.MaxCostUSD
+		}
+		if opts.Budget.MaxTokens == 0 {
+			opts.Budget.MaxTokens = saved.MaxTokens
 		}
+		opts.Budget.Hard = opts.Budget.Hard || saved.Hard
 	}
 }
 
+func currentHostname() string {
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+func describeOwner(s *state.RalphState) string {
+	owner := fmt.Sprintf("pid %d", s.PID)
+	if s.Hostname != "" {
+		owner += " on " + s.Hostname
+	}
+	if s.HeartbeatAt != "" {
+		owner += fmt.Sprintf(", last heartbeat %s ago", formatDurationLong(state.HeartbeatAge(s).Milliseconds()))
+	}
+	return owner
+}
+
+// confirmTakeover asks on the terminal whether a stale loop should be
+// replaced. Without a terminal there is nobody to ask, so it declines.
+func confirmTakeover(s *state.RalphState) bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	fmt.Printf("⚠️  Found a stale loop at iteration %d (%s).\n", s.Iteration, describeOwner(s))
+	fmt.Print("Take it over and start a new loop? [y/N] ")
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 func formatDurationLong(ms int64) string {
 	if ms < 0 {
 		ms = 0
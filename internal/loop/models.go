@@ -0,0 +1,123 @@
+package loop
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+// Defaults for moving along --model-chain.
+const (
+	DefaultEscalateAfter   = 3
+	DefaultDeescalateAfter = 2
+)
+
+// modelIndex returns the position of the current model in the chain, or -1.
+func modelIndex(s *state.RalphState) int {
+	for i, model := range s.ModelChain {
+		if model == s.Model {
+			return i
+		}
+	}
+	return -1
+}
+
+// changeModel switches the loop to model for the next iteration.
+func changeModel(s *state.RalphState, model, reason string, up bool) {
+	icon := "⬇️ "
+	if up {
+		icon = "⬆️ "
+	}
+	fmt.Printf("\n%s Switching model: %s → %s (%s)\n", icon, s.Model, model, reason)
+	emit(s, &state.Event{
+		Type:          state.EventModelChanged,
+		Iteration:     s.Iteration,
+		Model:         model,
+		PreviousModel: s.Model,
+		Reason:        reason,
+	})
+	s.Model = model
+	state.SaveState(s)
+}
+
+// nextModel returns the model after the current one in the chain, or "" at
+// the end of the chain.
+func nextModel(s *state.RalphState) string {
+	i := modelIndex(s)
+	if len(s.ModelChain) == 0 || i+1 >= len(s.ModelChain) {
+		return ""
+	}
+	return s.ModelChain[i+1]
+}
+
+// struggleReason says why the current model is stuck, or returns "", from
+// the struggle indicators: no file changes in the last n iterations, n very
+// short iterations in a row, or the same error in n of its iterations.
+func struggleReason(h *state.RalphHistory, stint []state.IterationHistory, n int) string {
+	indicators := h.StruggleIndicators
+	switch {
+	case indicators.NoProgressIterations >= n:
+		return fmt.Sprintf("no file changes in %d iterations", indicators.NoProgressIterations)
+	case indicators.ShortIterations >= n:
+		return fmt.Sprintf("%d very short iterations", indicators.ShortIterations)
+	}
+	// RepeatedErrors spans models, so the errors are counted again over the
+	// current model's iterations.
+	seen := map[string]int{}
+	for _, iter := range stint {
+		for _, msg := range slices.Compact(slices.Sorted(slices.Values(iter.Errors))) {
+			seen[msg]++
+			if seen[msg] >= n {
+				return fmt.Sprintf("the same error in %d iterations", n)
+			}
+		}
+	}
+	return ""
+}
+
+// adjustModel moves along the chain after an iteration: up to a stronger
+// model once the current one has struggled for opts.EscalateAfter
+// iterations, and back down once each of its last opts.DeescalateAfter
+// iterations changed files.
+func adjustModel(s *state.RalphState, h *state.RalphHistory, opts *LoopOptions) {
+	if len(s.ModelChain) < 2 {
+		return
+	}
+	i := modelIndex(s)
+
+	// The iterations run on the current model, newest first.
+	var stint []state.IterationHistory
+	for j := len(h.Iterations) - 1; j >= 0 && h.Iterations[j].Model == s.Model; j-- {
+		stint = append(stint, h.Iterations[j])
+	}
+
+	n := opts.EscalateAfter
+	if next := nextModel(s); next != "" && n > 0 && len(stint) >= n {
+		if reason := struggleReason(h, stint, n); reason != "" {
+			changeModel(s, next, reason, true)
+			return
+		}
+	}
+
+	n = opts.DeescalateAfter
+	if n > 0 && i > 0 && len(stint) >= n && !failedSince(h, s.ModelChain[i-1], stint[len(stint)-1].Iteration) {
+		for _, iter := range stint[:n] {
+			if len(iter.FilesModified) == 0 || iter.ExitCode != 0 {
+				return
+			}
+		}
+		changeModel(s, s.ModelChain[i-1], fmt.Sprintf("progress in the last %d iterations", n), false)
+	}
+}
+
+// failedSince reports whether model failed to run at or after iteration, so
+// the loop does not step back down onto a model it just fell back from.
+func failedSince(h *state.RalphHistory, model string, iteration int) bool {
+	for _, f := range h.AgentFailures {
+		if f.Model == model && f.Iteration >= iteration {
+			return true
+		}
+	}
+	return false
+}
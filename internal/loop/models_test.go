@@ -0,0 +1,55 @@
+package loop
+
+import (
+	"testing"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+func TestStruggleReason(t *testing.T) {
+	iter := func(errors ...string) state.IterationHistory {
+		return state.IterationHistory{Model: "a", Errors: errors}
+	}
+	tests := []struct {
+		name       string
+		indicators state.StruggleIndicators
+		stint      []state.IterationHistory
+		want       string
+	}{
+		{
+			name:  "making progress",
+			stint: []state.IterationHistory{iter(), iter("x"), iter()},
+		},
+		{
+			name:       "no file changes",
+			indicators: state.StruggleIndicators{NoProgressIterations: 4},
+			stint:      []state.IterationHistory{iter(), iter(), iter()},
+			want:       "no file changes in 4 iterations",
+		},
+		{
+			name:       "short iterations",
+			indicators: state.StruggleIndicators{ShortIterations: 3},
+			stint:      []state.IterationHistory{iter(), iter(), iter()},
+			want:       "3 very short iterations",
+		},
+		{
+			name:  "same error",
+			stint: []state.IterationHistory{iter("x", "y"), iter("y"), iter("y", "y")},
+			want:  "the same error in 3 iterations",
+		},
+		{
+			// The error repeated on the previous model does not count.
+			name:       "repeated error from another model",
+			indicators: state.StruggleIndicators{RepeatedErrors: map[string]int{"x": 5}},
+			stint:      []state.IterationHistory{iter("x"), iter("y"), iter("z")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &state.RalphHistory{StruggleIndicators: tt.indicators}
+			if got := struggleReason(h, tt.stint, 3); got != tt.want {
+				t.Errorf("struggleReason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
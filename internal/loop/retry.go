@@ -0,0 +1,188 @@
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wltechblog/ralphy/internal/agent"
+	"github.com/wltechblog/ralphy/internal/opencode"
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+// Defaults for retrying failed agent runs.
+const (
+	DefaultRetryDelay    = 5 * time.Second
+	DefaultRetryMaxDelay = 5 * time.Minute
+	DefaultMaxFailures   = 5
+)
+
+// Errors that retrying will not fix: the agent cannot be started, or the
+// provider rejects the credentials.
+var fatalFailurePattern = regexp.MustCompile(`(?i)executable file not found|command not found|permission denied|\b(401|403)\b|unauthorized|forbidden|invalid api.?key|invalid x-api-key|authentication|no credentials|not logged in`)
+
+// Errors from the provider or the network that usually go away on their own.
+var transientFailurePattern = regexp.MustCompile(`(?i)\b(429|500|502|503|504|529)\b|rate.?limit|too many requests|overloaded|internal server error|bad gateway|service unavailable|gateway timeout|connection (refused|reset)|econnreset|etimedout|temporarily unavailable|network error|socket hang up`)
+
+// agentFailure is a run of the agent that failed before it could work on the
+// task. The loop retries it without using up the iteration.
+type agentFailure struct {
+	kind string
+	err  error
+}
+
+func (f *agentFailure) Error() string { return f.err.Error() }
+func (f *agentFailure) Unwrap() error { return f.err }
+
+// classifyRunError classifies an error from starting or talking to the
+// agent. Anything that is not known to be fatal is worth another try.
+func classifyRunError(err error) *agentFailure {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) || fatalFailurePattern.MatchString(err.Error()) {
+		return &agentFailure{kind: state.FailureFatal, err: err}
+	}
+	return &agentFailure{kind: state.FailureTransient, err: err}
+}
+
+// classifyResult decides whether an agent that exited with an error failed
+// for reasons outside the task. Only runs that made no tool calls count: an
+// agent that got to work had its iteration, whatever ended it. Returns nil
+// for runs that should be recorded as an ordinary iteration.
+func classifyResult(result *agent.Result) *agentFailure {
+	if result.ExitCode == 0 || len(result.ToolCounts) > 0 {
+		return nil
+	}
+
+	var diagnostics []string
+	if stderr := strings.TrimSpace(result.StderrText); stderr != "" {
+		diagnostics = append(diagnostics, stderr)
+	}
+	for _, e := range result.Events {
+		if e.Type == opencode.EventError {
+			diagnostics = append(diagnostics, e.Error)
+		}
+	}
+	text := strings.Join(diagnostics, "\n")
+
+	kind := ""
+	switch {
+	case result.ExitCode == 126 || result.ExitCode == 127 || fatalFailurePattern.MatchString(text):
+		kind = state.FailureFatal
+	case transientFailurePattern.MatchString(text):
+		kind = state.FailureTransient
+	default:
+		return nil
+	}
+	return &agentFailure{kind: kind, err: fmt.Errorf("agent exited with code %d: %s", result.ExitCode, lastLine(text))}
+}
+
+func lastLine(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	return truncateError(strings.TrimSpace(lines[len(lines)-1]))
+}
+
+func truncateError(msg string) string {
+	if len(msg) > 200 {
+		return msg[:200] + "..."
+	}
+	return msg
+}
+
+// backoff returns the delay before retry attempt n (from 1): base doubled for
+// every earlier attempt, capped at limit, with the upper half jittered so that
+// loops sharing a provider do not retry in lockstep.
+func backoff(attempt int, base, limit time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	if delay < 2 {
+		return delay
+	}
+	return delay/2 + rand.N(delay/2)
+}
+
+// handleAgentFailure records a failed attempt at the current iteration and
+// falls back to the next model of the chain, waits before the next attempt
+// or, after too many in a row or a fatal failure, opens the circuit breaker:
+// the loop is paused until someone fixes the problem and runs `ralphy
+// unpause`. It reports whether the breaker opened, which ends the run of
+// failures; falling back to another model does not.
+func handleAgentFailure(s *state.RalphState, h *state.RalphHistory, f *agentFailure, attempt int, opts *LoopOptions, sd *shutdown) bool {
+	record := &state.AgentFailure{
+		Iteration: s.Iteration,
+		Attempt:   attempt,
+		At:        time.Now().Format(time.RFC3339),
+		Kind:      f.kind,
+		Error:     truncateError(f.Error()),
+		Model:     s.Model,
+	}
+	// A fatal failure, like a missing binary, fails on every model alike.
+	// Otherwise another model in --model-chain is tried straight away.
+	breaker := f.kind == state.FailureFatal || (opts.MaxFailures > 0 && attempt >= opts.MaxFailures)
+	if !breaker {
+		record.FallbackModel = nextModel(s)
+	}
+	var delay time.Duration
+	switch {
+	case breaker:
+		record.BreakerOpened = true
+	case record.FallbackModel == "":
+		delay = backoff(attempt, opts.RetryDelay, opts.RetryMaxDelay)
+		record.RetryInMs = delay.Milliseconds()
+	}
+	h.AgentFailures = append(h.AgentFailures, *record)
+	state.SaveHistory(h)
+	emit(s, &state.Event{Type: state.EventAgentFailure, Iteration: s.Iteration, Failure: record})
+
+	if record.FallbackModel != "" {
+		fmt.Printf("\n⚠️  %s agent failure on %s: %v\n", capitalize(f.kind), s.Model, f)
+		changeModel(s, record.FallbackModel, f.kind+" agent failure", true)
+		return false
+	}
+	if breaker {
+		fmt.Printf("\n🔌 Circuit breaker open: %s agent failure (%d in a row): %v\n", f.kind, attempt, f)
+		if err := state.RequestControl(state.ControlPause); err != nil {
+			fmt.Printf("⚠️  Failed to pause the loop: %v\n", err)
+		}
+		fmt.Println("   Fix the problem, then run 'ralphy unpause' to retry.")
+		return true
+	}
+
+	fmt.Printf("\n⚠️  %s agent failure (attempt %d): %v\n", capitalize(f.kind), attempt, f)
+	fmt.Printf("   Retrying iteration %d in %s (the iteration is not used up)\n", s.Iteration, delay.Round(time.Second))
+	waitForRetry(s, delay, sd)
+	return false
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// waitForRetry sleeps for delay, keeping the heartbeat fresh, unless the
+// loop is shut down first.
+func waitForRetry(s *state.RalphState, delay time.Duration, sd *shutdown) {
+	deadline := time.Now().Add(delay)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return
+		}
+		select {
+		case <-sd.cancel:
+			return
+		case <-time.After(min(remaining, 10*time.Second)):
+			state.Heartbeat(s)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package loop
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt       int
+		base, limit   time.Duration
+		least, beyond time.Duration
+	}{
+		{attempt: 1, base: time.Second, limit: 5 * time.Second, least: 500 * time.Millisecond, beyond: time.Second},
+		{attempt: 2, base: time.Second, limit: 5 * time.Second, least: time.Second, beyond: 2 * time.Second},
+		{attempt: 3, base: time.Second, limit: 5 * time.Second, least: 2 * time.Second, beyond: 4 * time.Second},
+		{attempt: 4, base: time.Second, limit: 5 * time.Second, least: 2500 * time.Millisecond, beyond: 5 * time.Second},
+		{attempt: 60, base: time.Second, limit: 5 * time.Second, least: 2500 * time.Millisecond, beyond: 5 * time.Second},
+		{attempt: 1, base: 10 * time.Second, limit: time.Second, least: 500 * time.Millisecond, beyond: time.Second},
+		{attempt: 3, base: 1, limit: 1, least: 1, beyond: 2},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			if d := backoff(tt.attempt, tt.base, tt.limit); d < tt.least || d >= tt.beyond {
+				t.Fatalf("backoff(%d, %v, %v) = %v, want [%v, %v)", tt.attempt, tt.base, tt.limit, d, tt.least, tt.beyond)
+			}
+		}
+	}
+}
+
+func TestHandleAgentFailure(t *testing.T) {
+	transient := &agentFailure{kind: state.FailureTransient, err: errors.New("429 rate limited")}
+	fatal := &agentFailure{kind: state.FailureFatal, err: errors.New("executable file not found")}
+	tests := []struct {
+		name        string
+		model       string
+		failure     *agentFailure
+		attempt     int
+		wantBreaker bool
+		wantModel   string
+	}{
+		{name: "transient falls back", model: "a", failure: transient, attempt: 1, wantModel: "b"},
+		{name: "fatal opens the breaker on any model", model: "a", failure: fatal, attempt: 1, wantBreaker: true, wantModel: "a"},
+		{name: "max failures across models", model: "b", failure: transient, attempt: 3, wantBreaker: true, wantModel: "b"},
+		{name: "last model retries", model: "c", failure: transient, attempt: 2, wantModel: "c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			s := &state.RalphState{Active: true, Iteration: 1, Model: tt.model, ModelChain: []string{"a", "b", "c"}}
+			h := &state.RalphHistory{}
+			opts := &LoopOptions{RetryDelay: time.Millisecond, RetryMaxDelay: time.Millisecond, MaxFailures: 3}
+
+			breaker := handleAgentFailure(s, h, tt.failure, tt.attempt, opts, newShutdown())
+			if breaker != tt.wantBreaker || s.Model != tt.wantModel {
+				t.Errorf("breaker = %v, model %s; want %v, %s", breaker, s.Model, tt.wantBreaker, tt.wantModel)
+			}
+			if len(h.AgentFailures) != 1 {
+				t.Fatalf("AgentFailures = %+v, want one", h.AgentFailures)
+			}
+			record := h.AgentFailures[0]
+			fallback := ""
+			if tt.wantModel != tt.model {
+				fallback = tt.wantModel
+			}
+			if record.BreakerOpened != tt.wantBreaker || record.FallbackModel != fallback {
+				t.Errorf("record = %+v", record)
+			}
+			if paused, _ := state.LoadControl(); (paused != nil) != tt.wantBreaker {
+				t.Errorf("pause requested = %v, want %v", paused != nil, tt.wantBreaker)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package loop
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/wltechblog/ralphy/internal/opencode"
+	"github.com/wltechblog/ralphy/internal/state"
+	"github.com/wltechblog/ralphy/internal/tools"
+)
+
+// maxCheckOutput is how much of a command's output is kept in history and
+// handed back to the agent. The end of the output is where failures show up.
+const maxCheckOutput = 4000
+
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// runCheck runs command through the shell and records whether it exited zero.
+func runCheck(command string, cancel <-chan struct{}) state.CheckResult {
+	start := time.Now()
+	var output bytes.Buffer
+
+	cmd := shellCommand(command)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	opencode.SetProcessGroup(cmd)
+
+	exitCode := 0
+	if err := cmd.Start(); err != nil {
+		output.WriteString(err.Error())
+		exitCode = -1
+	} else {
+		stopWatching := opencode.WatchCancel(cmd, cancel)
+		if err := cmd.Wait(); err != nil {
+			exitCode = -1
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitCode = exitErr.ExitCode()
+			}
+		}
+		stopWatching()
+		opencode.KillProcessGroup(cmd, opencode.KillGracePeriod)
+	}
+
+	return state.CheckResult{
+		Command:    command,
+		Passed:     exitCode == 0,
+		ExitCode:   exitCode,
+		DurationMs: time.Since(start).Milliseconds(),
+		Output:     tailOutput(output.String(), maxCheckOutput),
+	}
+}
+
+// runVerification runs every --verify command in order and reports whether
+// all of them passed.
+func runVerification(commands []string, cancel <-chan struct{}) ([]state.CheckResult, bool) {
+	fmt.Println("\n🔎 Verifying completion claim...")
+	return runCommands(commands, cancel)
+}
+
+// runIterationChecks runs the --check commands that give the agent feedback
+// after every iteration.
+func runIterationChecks(commands []string, cancel <-chan struct{}) []state.CheckResult {
+	fmt.Println("\n🧪 Running checks...")
+	results, _ := runCommands(commands, cancel)
+	return results
+}
+
+// runCommands runs commands in order, printing a line for each, and reports
+// whether all of them passed. It stops early if the loop is cancelled.
+func runCommands(commands []string, cancel <-chan struct{}) ([]state.CheckResult, bool) {
+	results := []state.CheckResult{}
+	passed := true
+	for _, command := range commands {
+		if opencode.Cancelled(cancel) {
+			return results, false
+		}
+		result := runCheck(command, cancel)
+		results = append(results, result)
+		if result.Passed {
+			fmt.Printf("   ✅ %s (%s)\n", command, tools.FormatDuration(result.DurationMs))
+		} else {
+			fmt.Printf("   ❌ %s (exit %d, %s)\n", command, result.ExitCode, tools.FormatDuration(result.DurationMs))
+			passed = false
+		}
+	}
+	return results, passed
+}
+
+// verificationContext explains a rejected completion claim to the agent,
+// including the output of every failing command.
+func verificationContext(completionPromise string, results []state.CheckResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You output <promise>%s</promise>, but the verification commands failed, so the loop continues. Fix the failures below before claiming completion again.\n", completionPromise)
+	for _, result := range results {
+		if result.Passed {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### `%s` (exit %d)\n\n```\n%s\n```\n", result.Command, result.ExitCode, strings.TrimSpace(result.Output))
+	}
+	return b.String()
+}
+
+func tailOutput(output string, max int) string {
+	if len(output) <= max {
+		return output
+	}
+	output = output[len(output)-max:]
+	if i := strings.IndexByte(output, '\n'); i >= 0 && i < len(output)-1 {
+		output = output[i+1:]
+	}
+	return "...\n" + output
+}
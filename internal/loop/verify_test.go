@@ -0,0 +1,81 @@
+package loop
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+func TestTailOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		max    int
+		want   string
+	}{
+		{output: "short", max: 10, want: "short"},
+		{output: "exactly10!", max: 10, want: "exactly10!"},
+		{output: "line one\nline two\nline three", max: 14, want: "...\nline three"},
+		{output: "abcdefghij", max: 4, want: "...\nghij"},
+		{output: "first\nlast\n", max: 6, want: "...\nlast\n"},
+	}
+	for _, tt := range tests {
+		if got := tailOutput(tt.output, tt.max); got != tt.want {
+			t.Errorf("tailOutput(%q, %d) = %q, want %q", tt.output, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestVerificationContext(t *testing.T) {
+	results := []state.CheckResult{
+		{Command: "go build ./...", Passed: true},
+		{Command: "go test ./...", ExitCode: 1, Output: "\nFAIL example\n"},
+	}
+	got := verificationContext("DONE", results)
+	want := "You output <promise>DONE</promise>, but the verification commands failed, so the loop continues. Fix the failures below before claiming completion again.\n" +
+		"\n### `go test ./...` (exit 1)\n\n```\nFAIL example\n```\n"
+	if got != want {
+		t.Errorf("verificationContext = %q, want %q", got, want)
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh commands")
+	}
+	tests := []struct {
+		name      string
+		commands  []string
+		wantPass  bool
+		wantCodes []int
+	}{
+		{name: "all pass", commands: []string{"true", "echo ok"}, wantPass: true, wantCodes: []int{0, 0}},
+		{name: "keeps going after a failure", commands: []string{"exit 3", "true"}, wantCodes: []int{3, 0}},
+		{name: "none", commands: nil, wantPass: true, wantCodes: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, passed := runCommands(tt.commands, nil)
+			if passed != tt.wantPass || len(results) != len(tt.wantCodes) {
+				t.Fatalf("runCommands = %+v, %v", results, passed)
+			}
+			for i, result := range results {
+				if result.ExitCode != tt.wantCodes[i] || result.Passed != (tt.wantCodes[i] == 0) {
+					t.Errorf("results[%d] = %+v, want exit %d", i, result, tt.wantCodes[i])
+				}
+			}
+		})
+	}
+
+	results, _ := runCommands([]string{"echo out; echo err >&2"}, nil)
+	if output := results[0].Output; !strings.Contains(output, "out\n") || !strings.Contains(output, "err\n") {
+		t.Errorf("Output = %q, want stdout and stderr", output)
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	if results, passed := runCommands([]string{"true"}, cancel); passed || len(results) != 0 {
+		t.Errorf("cancelled runCommands = %+v, %v; want nothing run", results, passed)
+	}
+}
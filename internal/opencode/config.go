@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/wltechblog/ralphy/internal/state"
+	"github.com/wltechblog/ralphy/internal/tools"
 )
 
 func LoadPluginsFromConfig(configPath string) []string {
@@ -72,7 +73,7 @@ func ensureRalphConfig(options *ConfigOptions) (string, error) {
 		}
 
 		userConfigPath := filepath.Join(xdgConfigHome, "opencode", "opencode.json")
-		projectConfigPath := filepath.Join(stateDir, "..", ".opencode", "opencode.json")
+		projectConfigPath := filepath.Join(".opencode", "opencode.json")
 
 		plugins = append(plugins, LoadPluginsFromConfig(userConfigPath)...)
 		plugins = append(plugins, LoadPluginsFromConfig(projectConfigPath)...)
@@ -138,9 +139,13 @@ type ConfigOptions struct {
 	AllowAllPermissions bool
 }
 
-func BuildPrompt(s *state.RalphState, context string) string {
+func BuildPrompt(s *state.RalphState, context string, checks []state.CheckResult) string {
 	var contextSection strings.Builder
 
+	if len(checks) > 0 {
+		contextSection.WriteString(formatChecksSection(checks))
+	}
+
 	if context != "" {
 		contextSection.WriteString(`
 ## Additional Context (added by user mid-loop)
@@ -165,7 +170,7 @@ You are in an iterative development loop working through a task list.
 ## Critical Rules
 
 - **Update your todo list and PROGRESS.md at the start of each iteration** to show progress. PROGRESS.md ensures your status persists across iterations.
-- Work on ONE task at a time from .opencode/ralph-tasks.md
+- Work on ONE task at a time from %s
 - ONLY output <promise>%s</promise> when the current task is complete and marked in ralph-tasks.md
 - ONLY output <promise>%s</promise> when ALL tasks are truly done
 - Do NOT lie or output false promises to exit the loop
@@ -179,6 +184,7 @@ Now, work on the current task. Good luck!`,
 		contextSection.String(),
 		tasksSection,
 		s.Prompt,
+		state.TasksDisplayPath(),
 		s.TaskPromise,
 		s.CompletionPromise,
 		s.Iteration,
@@ -187,6 +193,41 @@ Now, work on the current task. Good luck!`,
 	return strings.TrimSpace(prompt)
 }
 
+// maxPromptCheckOutput limits how much of a failing check's output is
+// repeated in the prompt.
+const maxPromptCheckOutput = 1500
+
+func formatChecksSection(checks []state.CheckResult) string {
+	var b strings.Builder
+	b.WriteString(`
+## Check Results From the Previous Iteration
+
+These commands ran automatically after the previous iteration. Fix any failures before moving on.
+
+`)
+	for _, check := range checks {
+		if check.Passed {
+			fmt.Fprintf(&b, "- ✅ `%s` passed (%s)\n", check.Command, tools.FormatDuration(check.DurationMs))
+		} else {
+			fmt.Fprintf(&b, "- ❌ `%s` failed with exit code %d (%s)\n", check.Command, check.ExitCode, tools.FormatDuration(check.DurationMs))
+		}
+	}
+	for _, check := range checks {
+		output := strings.TrimSpace(check.Output)
+		if check.Passed || output == "" {
+			continue
+		}
+		if len(output) > maxPromptCheckOutput {
+			output = "...\n" + output[len(output)-maxPromptCheckOutput:]
+		}
+		fmt.Fprintf(&b, "\nOutput of `%s`:\n\n```\n%s\n```\n", check.Command, output)
+	}
+	b.WriteString(`
+---
+`)
+	return b.String()
+}
+
 func formatMaxIterations(maxIterations int) string {
 	if maxIterations > 0 {
 		return fmt.Sprintf(" / %d", maxIterations)
@@ -0,0 +1,60 @@
+package opencode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+func TestFormatChecksSection(t *testing.T) {
+	long := strings.Repeat("x", maxPromptCheckOutput) + "tail"
+	tests := []struct {
+		name    string
+		checks  []state.CheckResult
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "passing checks list no output",
+			checks:  []state.CheckResult{{Command: "go vet ./...", Passed: true, DurationMs: 65000, Output: "all good"}},
+			want:    []string{"- ✅ `go vet ./...` passed (1:05)\n"},
+			notWant: []string{"all good", "Output of"},
+		},
+		{
+			name:   "failing check includes its output",
+			checks: []state.CheckResult{{Command: "go test ./...", ExitCode: 2, DurationMs: 3000, Output: "\nFAIL pkg\n"}},
+			want:   []string{"- ❌ `go test ./...` failed with exit code 2 (0:03)\n", "\nOutput of `go test ./...`:\n\n```\nFAIL pkg\n```\n"},
+		},
+		{
+			name:    "failing check without output",
+			checks:  []state.CheckResult{{Command: "false", ExitCode: 1}},
+			want:    []string{"- ❌ `false` failed with exit code 1 (0:00)\n"},
+			notWant: []string{"Output of"},
+		},
+		{
+			name:    "long output keeps the end",
+			checks:  []state.CheckResult{{Command: "make", ExitCode: 1, Output: long}},
+			want:    []string{"```\n...\n" + strings.Repeat("x", maxPromptCheckOutput-4) + "tail\n```\n"},
+			notWant: []string{strings.Repeat("x", maxPromptCheckOutput-3) + "tail"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatChecksSection(tt.checks)
+			if !strings.Contains(got, "## Check Results From the Previous Iteration") {
+				t.Errorf("missing heading in %q", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("%q does not contain %q", got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("%q contains %q", got, notWant)
+				}
+			}
+		})
+	}
+}
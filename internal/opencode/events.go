@@ -0,0 +1,321 @@
+package opencode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	EventText       = "text"
+	EventToolUse    = "tool_use"
+	EventStepStart  = "step_start"
+	EventStepFinish = "step_finish"
+	EventError      = "error"
+)
+
+// Event is one decoded entry of OpenCode's JSON event stream
+// (`opencode run --format json`).
+type Event struct {
+	Type      string      `json:"type"`
+	Timestamp int64       `json:"timestamp"`
+	SessionID string      `json:"sessionID,omitempty"`
+	Text      string      `json:"text,omitempty"`
+	Tool      *ToolCall   `json:"tool,omitempty"`
+	Step      *StepFinish `json:"step,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type ToolCall struct {
+	CallID string                 `json:"callID"`
+	Name   string                 `json:"name"`
+	Status string                 `json:"status"`
+	Title  string                 `json:"title,omitempty"`
+	Input  map[string]interface{} `json:"input,omitempty"`
+	Output string                 `json:"output,omitempty"`
+}
+
+type StepFinish struct {
+	Reason string     `json:"reason"`
+	Cost   float64    `json:"cost"`
+	Tokens TokenUsage `json:"tokens"`
+}
+
+type TokenUsage struct {
+	Input      int64 `json:"input"`
+	Output     int64 `json:"output"`
+	Reasoning  int64 `json:"reasoning"`
+	CacheRead  int64 `json:"cacheRead"`
+	CacheWrite int64 `json:"cacheWrite"`
+}
+
+func (u *TokenUsage) Add(other TokenUsage) {
+	u.Input += other.Input
+	u.Output += other.Output
+	u.Reasoning += other.Reasoning
+	u.CacheRead += other.CacheRead
+	u.CacheWrite += other.CacheWrite
+}
+
+func (u TokenUsage) Total() int64 {
+	return u.Input + u.Output + u.Reasoning + u.CacheRead + u.CacheWrite
+}
+
+type rawEvent struct {
+	Type      string          `json:"type"`
+	Timestamp int64           `json:"timestamp"`
+	SessionID string          `json:"sessionID"`
+	Part      *rawPart        `json:"part"`
+	Error     json.RawMessage `json:"error"`
+}
+
+type rawPart struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	SessionID string `json:"sessionID"`
+	MessageID string `json:"messageID"`
+	Text      string `json:"text"`
+	CallID    string `json:"callID"`
+	Tool      string `json:"tool"`
+	State     struct {
+		Status string                 `json:"status"`
+		Input  map[string]interface{} `json:"input"`
+		Output string                 `json:"output"`
+		Title  string                 `json:"title"`
+		Error  string                 `json:"error"`
+	} `json:"state"`
+	Time struct {
+		Start int64 `json:"start"`
+		End   int64 `json:"end"`
+	} `json:"time"`
+	Reason string  `json:"reason"`
+	Cost   float64 `json:"cost"`
+	Tokens struct {
+		Input     int64 `json:"input"`
+		Output    int64 `json:"output"`
+		Reasoning int64 `json:"reasoning"`
+		Cache     struct {
+			Read  int64 `json:"read"`
+			Write int64 `json:"write"`
+		} `json:"cache"`
+	} `json:"tokens"`
+}
+
+func DecodeEvent(line string) (*Event, error) {
+	var raw rawEvent
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, err
+	}
+	if raw.Type == "" {
+		return nil, fmt.Errorf("event has no type")
+	}
+
+	if raw.Type == EventError {
+		return &Event{
+			Type:      EventError,
+			Timestamp: raw.Timestamp,
+			SessionID: raw.SessionID,
+			Error:     decodeErrorMessage(raw.Error),
+		}, nil
+	}
+
+	// Events without a part ralphy can decode are kept without a type, so
+	// nothing reads a Tool or Step they do not have.
+	event := &Event{}
+	if raw.Part != nil {
+		if decoded := eventFromPart(raw.Part); decoded != nil {
+			event = decoded
+		}
+	}
+	event.Timestamp = raw.Timestamp
+	if raw.SessionID != "" {
+		event.SessionID = raw.SessionID
+	}
+	return event, nil
+}
+
+// eventFromPart converts an OpenCode message part into an Event, or returns
+// nil for part types ralphy does not track.
+func eventFromPart(part *rawPart) *Event {
+	switch part.Type {
+	case "text":
+		return &Event{Type: EventText, SessionID: part.SessionID, Text: part.Text}
+	case "tool":
+		return &Event{
+			Type:      EventToolUse,
+			SessionID: part.SessionID,
+			Tool: &ToolCall{
+				CallID: part.CallID,
+				Name:   part.Tool,
+				Status: part.State.Status,
+				Title:  part.State.Title,
+				Input:  part.State.Input,
+				Output: part.State.Output,
+			},
+		}
+	case "step-start":
+		return &Event{Type: EventStepStart, SessionID: part.SessionID}
+	case "step-finish":
+		return &Event{
+			Type:      EventStepFinish,
+			SessionID: part.SessionID,
+			Step: &StepFinish{
+				Reason: part.Reason,
+				Cost:   part.Cost,
+				Tokens: TokenUsage{
+					Input:      part.Tokens.Input,
+					Output:     part.Tokens.Output,
+					Reasoning:  part.Tokens.Reasoning,
+					CacheRead:  part.Tokens.Cache.Read,
+					CacheWrite: part.Tokens.Cache.Write,
+				},
+			},
+		}
+	}
+	return nil
+}
+
+func decodeErrorMessage(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return "unknown error"
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var obj struct {
+		Name    string `json:"name"`
+		Message string `json:"message"`
+		Data    struct {
+			Message string `json:"message"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		switch {
+		case obj.Data.Message != "":
+			return obj.Data.Message
+		case obj.Message != "":
+			return obj.Message
+		case obj.Name != "":
+			return obj.Name
+		}
+	}
+	return string(raw)
+}
+
+// eventCollector accumulates decoded events into a StreamResult and renders
+// them to the terminal the same way the text stream is rendered.
+type eventCollector struct {
+	monitor *streamMonitor
+	quiet   bool
+	events  []Event
+	text    strings.Builder
+	usage   TokenUsage
+	cost    float64
+}
+
+func (c *eventCollector) handle(event *Event) {
+	c.events = append(c.events, *event)
+	switch event.Type {
+	case EventText:
+		c.text.WriteString(event.Text + "\n")
+		c.print(strings.TrimRight(event.Text, "\n"), false)
+	case EventToolUse:
+		name := displayToolName(event.Tool.Name)
+		c.monitor.countTool(name)
+		if !c.monitor.compactTools {
+			c.print(fmt.Sprintf("| %-8s %s", name, event.Tool.Title), false)
+		}
+	case EventStepFinish:
+		c.usage.Add(event.Step.Tokens)
+		c.cost += event.Step.Cost
+		if c.monitor.onUsage != nil {
+			c.monitor.onUsage(c.usage, c.cost)
+		}
+	case EventError:
+		c.print("❌ "+event.Error, true)
+	}
+}
+
+func (c *eventCollector) handleLine(line string) {
+	event, err := DecodeEvent(line)
+	if err != nil {
+		c.text.WriteString(line + "\n")
+		c.print(line, false)
+		return
+	}
+	c.handle(event)
+}
+
+func (c *eventCollector) print(line string, isError bool) {
+	if !c.quiet {
+		c.monitor.printLine(line, isError)
+	}
+}
+
+func (c *eventCollector) result(stderrText string) *StreamResult {
+	return &StreamResult{
+		StdoutText: c.text.String(),
+		StderrText: stderrText,
+		ToolCounts: maps.Clone(c.monitor.toolCounts),
+		Events:     slices.Clone(c.events),
+		Usage:      c.usage,
+		Cost:       c.cost,
+	}
+}
+
+func displayToolName(name string) string {
+	if name == "" {
+		return "unknown"
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// StreamJSONEvents decodes OpenCode's JSON event stream as it arrives. Lines
+// that are not JSON events are passed through as plain text.
+func StreamJSONEvents(stdout, stderr io.Reader, opts *StreamOptions) (*StreamResult, error) {
+	m := newStreamMonitor(opts.CompactTools)
+	c := &eventCollector{monitor: m}
+	var stderrText strings.Builder
+
+	handleStderr := func(line string) {
+		stderrText.WriteString(line + "\n")
+		m.printLine(line, true)
+	}
+
+	err := m.run(stdout, stderr, c.handleLine, handleStderr, opts)
+
+	// After a cancel or timeout the readers may still be running; the
+	// result holds what they had read by then.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return c.result(stderrText.String()), err
+}
+
+func BufferJSONEvents(stdout, stderr io.Reader) (*StreamResult, error) {
+	stdoutData, err := io.ReadAll(stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	stderrData, err := io.ReadAll(stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &eventCollector{monitor: newStreamMonitor(false), quiet: true}
+	for _, line := range strings.Split(string(stdoutData), "\n") {
+		if strings.TrimSpace(line) != "" {
+			c.handleLine(line)
+		}
+	}
+
+	fmt.Print(c.text.String())
+	fmt.Fprint(os.Stderr, string(stderrData))
+
+	return c.result(string(stderrData)), nil
+}
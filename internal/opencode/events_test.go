@@ -0,0 +1,105 @@
+package opencode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want *Event
+		err  bool
+	}{
+		{
+			name: "text",
+			line: `{"type":"text","timestamp":1,"sessionID":"s1","part":{"type":"text","text":"hello"}}`,
+			want: &Event{Type: EventText, Timestamp: 1, SessionID: "s1", Text: "hello"},
+		},
+		{
+			name: "tool",
+			line: `{"type":"tool_use","timestamp":2,"part":{"type":"tool","sessionID":"s1","callID":"c1","tool":"bash","state":{"status":"completed","title":"ls","output":"a.go"}}}`,
+			want: &Event{Type: EventToolUse, Timestamp: 2, SessionID: "s1", Tool: &ToolCall{CallID: "c1", Name: "bash", Status: "completed", Title: "ls", Output: "a.go"}},
+		},
+		{
+			name: "step finish",
+			line: `{"type":"step_finish","timestamp":3,"part":{"type":"step-finish","reason":"stop","cost":0.5,"tokens":{"input":10,"output":20,"reasoning":5,"cache":{"read":100,"write":7}}}}`,
+			want: &Event{Type: EventStepFinish, Timestamp: 3, Step: &StepFinish{Reason: "stop", Cost: 0.5, Tokens: TokenUsage{Input: 10, Output: 20, Reasoning: 5, CacheRead: 100, CacheWrite: 7}}},
+		},
+		{
+			name: "error object",
+			line: `{"type":"error","timestamp":4,"error":{"name":"APIError","data":{"message":"rate limited"}}}`,
+			want: &Event{Type: EventError, Timestamp: 4, Error: "rate limited"},
+		},
+		{
+			name: "error string",
+			line: `{"type":"error","error":"boom"}`,
+			want: &Event{Type: EventError, Error: "boom"},
+		},
+		{
+			name: "tool use without a part",
+			line: `{"type":"tool_use","timestamp":5,"sessionID":"s1"}`,
+			want: &Event{Timestamp: 5, SessionID: "s1"},
+		},
+		{
+			name: "step finish with an unknown part",
+			line: `{"type":"step_finish","timestamp":6,"part":{"type":"snapshot"}}`,
+			want: &Event{Timestamp: 6},
+		},
+		{name: "no type", line: `{"timestamp":7}`, err: true},
+		{name: "not json", line: `plain output`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeEvent(tt.line)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("DecodeEvent = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeEvent = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventCollector(t *testing.T) {
+	lines := []string{
+		`{"type":"step_start","part":{"type":"step-start"}}`,
+		`{"type":"text","part":{"type":"text","text":"working"}}`,
+		`{"type":"tool_use","part":{"type":"tool","tool":"bash","state":{"title":"ls"}}}`,
+		`{"type":"tool_use","part":{"type":"tool","tool":"read","state":{"title":"a.go"}}}`,
+		`{"type":"tool_use","part":{"type":"tool","tool":"bash","state":{"title":"go test"}}}`,
+		`{"type":"tool_use"}`,
+		`{"type":"step_finish","part":{"type":"patch"}}`,
+		`{"type":"step_finish","part":{"type":"step-finish","cost":0.25,"tokens":{"input":10,"output":5,"cache":{"read":100}}}}`,
+		`{"type":"step_finish","part":{"type":"step-finish","cost":0.5,"tokens":{"input":20,"output":5}}}`,
+		`not an event`,
+		`{"type":"error","error":"boom"}`,
+	}
+
+	c := &eventCollector{monitor: newStreamMonitor(false), quiet: true}
+	for _, line := range lines {
+		c.handleLine(line)
+	}
+	result := c.result("")
+
+	if result.StdoutText != "working\nnot an event\n" {
+		t.Errorf("StdoutText = %q", result.StdoutText)
+	}
+	if want := map[string]int{"Bash": 2, "Read": 1}; !reflect.DeepEqual(result.ToolCounts, want) {
+		t.Errorf("ToolCounts = %v, want %v", result.ToolCounts, want)
+	}
+	if want := (TokenUsage{Input: 30, Output: 10, CacheRead: 100}); result.Usage != want || result.Cost != 0.75 {
+		t.Errorf("Usage = %+v, cost %v; want %+v, 0.75", result.Usage, result.Cost, want)
+	}
+	if len(result.Events) != len(lines)-1 {
+		t.Errorf("got %d events, want %d", len(result.Events), len(lines)-1)
+	}
+}
@@ -0,0 +1,65 @@
+//go:build !windows
+
+package opencode
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// SetProcessGroup makes cmd the leader of a new process group so the agent
+// and everything it spawns can be signalled together.
+func SetProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// InterruptProcessGroup asks cmd's process group to exit with SIGTERM.
+func InterruptProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+}
+
+// KillProcessGroup sends SIGTERM to cmd's process group, waits up to grace
+// for it to exit and then sends SIGKILL to whatever is left. It reaps cmd if
+// it has not been waited for yet, so it must be called from the goroutine
+// that owns cmd.
+func KillProcessGroup(cmd *exec.Cmd, grace time.Duration) {
+	if cmd.Process == nil {
+		return
+	}
+	pgid := cmd.Process.Pid
+
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+		if !errors.Is(err, syscall.ESRCH) {
+			cmd.Process.Kill()
+		}
+		if cmd.ProcessState == nil {
+			cmd.Wait()
+		}
+		return
+	}
+
+	deadline := time.Now().Add(grace)
+	if cmd.ProcessState == nil {
+		// An unreaped leader keeps the group alive, so wait for it first.
+		timer := time.AfterFunc(grace, func() {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		})
+		cmd.Wait()
+		timer.Stop()
+	}
+
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(-pgid, 0); errors.Is(err, syscall.ESRCH) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	syscall.Kill(-pgid, syscall.SIGKILL)
+}
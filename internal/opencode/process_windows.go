@@ -0,0 +1,26 @@
+//go:build windows
+
+package opencode
+
+import (
+	"os/exec"
+	"time"
+)
+
+func SetProcessGroup(cmd *exec.Cmd) {}
+
+func InterruptProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
+
+func KillProcessGroup(cmd *exec.Cmd, grace time.Duration) {
+	if cmd.Process == nil {
+		return
+	}
+	cmd.Process.Kill()
+	if cmd.ProcessState == nil {
+		cmd.Wait()
+	}
+}
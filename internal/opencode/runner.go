@@ -1,12 +1,23 @@
 package opencode
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"time"
 )
 
+var ErrCancelled = errors.New("agent run cancelled")
+
+// ErrInactivityTimeout ends a run whose agent was quiet for longer than its
+// timeout.
+var ErrInactivityTimeout = errors.New("inactivity timeout")
+
+// KillGracePeriod is how long an agent's process group gets to exit after
+// SIGTERM before it is killed outright.
+const KillGracePeriod = 5 * time.Second
+
 type RunOpenCodeOptions struct {
 	Prompt              string
 	Model               string
@@ -17,6 +28,12 @@ type RunOpenCodeOptions struct {
 	IterationStart      time.Time
 	Verbose             bool
 	Timeout             time.Duration
+	ToolMatcher         ToolMatcher
+	JSONEvents          bool
+	Heartbeat           func()
+	OnTool              func(name string)
+	OnUsage             func(usage TokenUsage, cost float64)
+	Cancel              <-chan struct{}
 }
 
 func RunOpenCode(opts *RunOpenCodeOptions) (*StreamResult, int, error) {
@@ -30,6 +47,10 @@ func RunOpenCode(opts *RunOpenCodeOptions) (*StreamResult, int, error) {
 		args = append(args, "--log-level", "DEBUG")
 	}
 
+	if opts.JSONEvents {
+		args = append(args, "--format", "json")
+	}
+
 	args = append(args, opts.Prompt)
 
 	env := os.Environ()
@@ -47,7 +68,11 @@ func RunOpenCode(opts *RunOpenCodeOptions) (*StreamResult, int, error) {
 
 	cmd := exec.Command("opencode", args...)
 	cmd.Env = env
-	cmd.Stdin = os.Stdin
+	// In its own process group the agent is in the background as far as the
+	// terminal is concerned, and reading it would stop the agent with
+	// SIGTTIN. Stdin stays nil, which is /dev/null: a prompt sees the end of
+	// its input instead of hanging the loop.
+	SetProcessGroup(cmd)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -62,25 +87,34 @@ func RunOpenCode(opts *RunOpenCodeOptions) (*StreamResult, int, error) {
 		return nil, -1, fmt.Errorf("failed to start opencode: %w", err)
 	}
 
-	defer func() {
-		if cmd.Process != nil {
-			cmd.Process.Kill()
-		}
-	}()
+	defer KillProcessGroup(cmd, KillGracePeriod)
+
+	streamOpts := &StreamOptions{
+		CompactTools:   !opts.VerboseTools,
+		IterationStart: opts.IterationStart,
+		Timeout:        opts.Timeout,
+		MatchTool:      opts.ToolMatcher,
+		Heartbeat:      opts.Heartbeat,
+		OnTool:         opts.OnTool,
+		OnUsage:        opts.OnUsage,
+		Cancel:         opts.Cancel,
+	}
+	stopWatching := WatchCancel(cmd, opts.Cancel)
+	defer stopWatching()
 
 	var result *StreamResult
-	if opts.StreamOutput {
-		result, err = StreamProcessOutput(stdout, stderr, !opts.VerboseTools, opts.IterationStart, opts.Timeout)
-		if err != nil {
-			cmd.Process.Kill()
-			return nil, -1, err
-		}
-	} else {
-		result, err = BufferProcessOutput(stdout, stderr)
-		if err != nil {
-			cmd.Process.Kill()
-			return nil, -1, err
-		}
+	switch {
+	case opts.JSONEvents && opts.StreamOutput:
+		result, err = StreamJSONEvents(stdout, stderr, streamOpts)
+	case opts.JSONEvents:
+		result, err = BufferJSONEvents(stdout, stderr)
+	case opts.StreamOutput:
+		result, err = StreamProcessOutput(stdout, stderr, streamOpts)
+	default:
+		result, err = BufferProcessOutput(stdout, stderr, opts.ToolMatcher)
+	}
+	if err != nil {
+		return result, -1, err
 	}
 
 	exitCode := 0
@@ -90,5 +124,32 @@ func RunOpenCode(opts *RunOpenCodeOptions) (*StreamResult, int, error) {
 		}
 	}
 
+	if Cancelled(opts.Cancel) {
+		return result, -1, ErrCancelled
+	}
+
 	return result, exitCode, nil
 }
+
+// WatchCancel interrupts cmd's process group as soon as cancel is closed.
+// The returned function stops watching.
+func WatchCancel(cmd *exec.Cmd, cancel <-chan struct{}) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-cancel:
+			InterruptProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+func Cancelled(cancel <-chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+	default:
+		return false
+	}
+}
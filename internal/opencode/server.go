@@ -0,0 +1,321 @@
+package opencode
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type ServerClient struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+func NewServerClient(baseURL string) *ServerClient {
+	return &ServerClient{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    &http.Client{},
+	}
+}
+
+type ServerOptions struct {
+	DisablePlugins      bool
+	AllowAllPermissions bool
+	Verbose             bool
+}
+
+// StartServer launches `opencode serve` on a free local port and waits until
+// it answers HTTP requests.
+func StartServer(opts *ServerOptions) (*exec.Cmd, *ServerClient, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find a free port: %w", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	args := []string{"serve", "--hostname", "127.0.0.1", "--port", fmt.Sprintf("%d", port)}
+	if opts.Verbose {
+		args = append(args, "--log-level", "DEBUG")
+	}
+
+	env := os.Environ()
+	if opts.DisablePlugins || opts.AllowAllPermissions {
+		configPath, err := ensureRalphConfig(&ConfigOptions{
+			FilterPlugins:       opts.DisablePlugins,
+			AllowAllPermissions: opts.AllowAllPermissions,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create Ralph config: %w", err)
+		}
+		env = append(env, fmt.Sprintf("OPENCODE_CONFIG=%s", configPath))
+	}
+
+	cmd := exec.Command("opencode", args...)
+	cmd.Env = env
+	SetProcessGroup(cmd)
+	if opts.Verbose {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("failed to start opencode server: %w", err)
+	}
+
+	client := NewServerClient(fmt.Sprintf("http://127.0.0.1:%d", port))
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		if client.Healthy() {
+			return cmd, client, nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	KillProcessGroup(cmd, KillGracePeriod)
+	return nil, nil, fmt.Errorf("opencode server did not become ready on port %d", port)
+}
+
+func (c *ServerClient) Healthy() bool {
+	resp, err := c.HTTP.Get(c.BaseURL + "/session")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func (c *ServerClient) post(path string, body interface{}, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTP.Post(c.BaseURL+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("POST %s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *ServerClient) CreateSession(title string) (string, error) {
+	var session struct {
+		ID string `json:"id"`
+	}
+	if err := c.post("/session", map[string]string{"title": title}, &session); err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	if session.ID == "" {
+		return "", fmt.Errorf("failed to create session: server returned no session id")
+	}
+	return session.ID, nil
+}
+
+func (c *ServerClient) SendPrompt(sessionID, prompt, model string) error {
+	body := map[string]interface{}{
+		"parts": []map[string]string{{"type": "text", "text": prompt}},
+	}
+	if providerID, modelID, ok := strings.Cut(model, "/"); ok {
+		body["model"] = map[string]string{"providerID": providerID, "modelID": modelID}
+	}
+	return c.post("/session/"+sessionID+"/message", body, nil)
+}
+
+func (c *ServerClient) Abort(sessionID string) error {
+	return c.post("/session/"+sessionID+"/abort", map[string]string{}, nil)
+}
+
+// Events opens the server-sent event stream. Each `data:` payload is
+// written to the returned reader as a single line; closing the writer or
+// cancelling ctx ends the stream.
+func (c *ServerClient) Events(ctx context.Context) (*io.PipeReader, *io.PipeWriter, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/event", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open event stream: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("failed to open event stream: %s", resp.Status)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+		var data strings.Builder
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "data:") {
+				if data.Len() > 0 {
+					data.WriteString(" ")
+				}
+				data.WriteString(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+				continue
+			}
+			if line == "" && data.Len() > 0 {
+				if _, err := pw.Write([]byte(data.String() + "\n")); err != nil {
+					return
+				}
+				data.Reset()
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.Close()
+	}()
+
+	return pr, pw, nil
+}
+
+type serverEvent struct {
+	Type       string `json:"type"`
+	Properties struct {
+		SessionID string          `json:"sessionID"`
+		Part      *rawPart        `json:"part"`
+		Error     json.RawMessage `json:"error"`
+		Info      struct {
+			ID        string `json:"id"`
+			SessionID string `json:"sessionID"`
+			Role      string `json:"role"`
+		} `json:"info"`
+	} `json:"properties"`
+}
+
+type RunServerOptions struct {
+	Client         *ServerClient
+	SessionID      string
+	Prompt         string
+	Model          string
+	VerboseTools   bool
+	Quiet          bool
+	IterationStart time.Time
+	Timeout        time.Duration
+	Heartbeat      func()
+	OnTool         func(name string)
+	OnUsage        func(usage TokenUsage, cost float64)
+	Cancel         <-chan struct{}
+}
+
+// RunServerSession posts a prompt to an OpenCode server session and renders
+// the session's event stream until the session goes idle.
+func RunServerSession(opts *RunServerOptions) (*StreamResult, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, eventsWriter, err := opts.Client.Events(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer events.Close()
+
+	m := newStreamMonitor(!opts.VerboseTools && !opts.Quiet)
+	c := &eventCollector{monitor: m, quiet: opts.Quiet}
+	var stderrText strings.Builder
+
+	userMessages := map[string]bool{}
+	seenParts := map[string]bool{}
+
+	handleEvent := func(line string) {
+		var ev serverEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			return
+		}
+		props := ev.Properties
+
+		switch ev.Type {
+		case "message.updated":
+			if props.Info.SessionID == opts.SessionID && props.Info.Role == "user" {
+				userMessages[props.Info.ID] = true
+			}
+		case "message.part.updated":
+			part := props.Part
+			if part == nil || part.SessionID != opts.SessionID || userMessages[part.MessageID] || seenParts[part.ID] {
+				return
+			}
+			if !partFinished(part) {
+				return
+			}
+			seenParts[part.ID] = true
+			if event := eventFromPart(part); event != nil {
+				event.Timestamp = time.Now().UnixMilli()
+				c.handle(event)
+			}
+		case "session.error":
+			if props.SessionID != "" && props.SessionID != opts.SessionID {
+				return
+			}
+			msg := decodeErrorMessage(props.Error)
+			stderrText.WriteString(msg + "\n")
+			c.handle(&Event{Type: EventError, Timestamp: time.Now().UnixMilli(), SessionID: opts.SessionID, Error: msg})
+		case "session.idle":
+			if props.SessionID == opts.SessionID {
+				eventsWriter.Close()
+			}
+		}
+	}
+
+	// A prompt the server rejects never starts the session, so it would not
+	// go idle either: end the stream with the error instead of waiting for
+	// the inactivity timeout.
+	go func() {
+		if err := opts.Client.SendPrompt(opts.SessionID, opts.Prompt, opts.Model); err != nil {
+			eventsWriter.CloseWithError(fmt.Errorf("failed to send prompt: %w", err))
+			cancel()
+		}
+	}()
+
+	streamOpts := &StreamOptions{
+		IterationStart: opts.IterationStart,
+		Timeout:        opts.Timeout,
+		Heartbeat:      opts.Heartbeat,
+		OnTool:         opts.OnTool,
+		OnUsage:        opts.OnUsage,
+		Cancel:         opts.Cancel,
+	}
+	err = m.run(events, nil, handleEvent, nil, streamOpts)
+	if err != nil {
+		opts.Client.Abort(opts.SessionID)
+	}
+
+	// The result holds what arrived before a cancel or timeout.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return c.result(stderrText.String()), err
+}
+
+func partFinished(part *rawPart) bool {
+	switch part.Type {
+	case "text":
+		return part.Time.End != 0
+	case "tool":
+		return part.State.Status == "completed" || part.State.Status == "error"
+	case "step-start", "step-finish":
+		return true
+	}
+	return false
+}
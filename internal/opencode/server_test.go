@@ -0,0 +1,162 @@
+package opencode
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is a minimal `opencode serve`: it accepts one prompt and then
+// replays events on the event stream.
+type fakeServer struct {
+	events       []string
+	promptStatus int
+
+	mu       sync.Mutex
+	prompt   map[string]interface{}
+	prompted chan struct{}
+	closed   chan struct{}
+}
+
+func newFakeServer(t *testing.T, f *fakeServer) *httptest.Server {
+	f.prompted = make(chan struct{})
+	f.closed = make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /event", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-f.prompted:
+		case <-r.Context().Done():
+			return
+		case <-f.closed:
+			return
+		}
+		for _, e := range f.events {
+			fmt.Fprintf(w, "data: %s\n\n", e)
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-r.Context().Done():
+		case <-f.closed:
+		}
+	})
+	mux.HandleFunc("POST /session/{id}/message", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		json.NewDecoder(r.Body).Decode(&f.prompt)
+		f.mu.Unlock()
+		if f.promptStatus != 0 {
+			http.Error(w, "bad prompt", f.promptStatus)
+			return
+		}
+		close(f.prompted)
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("POST /session/{id}/abort", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("true"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	// Cleanups run last first: end open event streams so Close returns.
+	t.Cleanup(func() { close(f.closed) })
+	return srv
+}
+
+func runFakeSession(t *testing.T, f *fakeServer) (*StreamResult, error) {
+	t.Helper()
+	srv := newFakeServer(t, f)
+	done := make(chan struct{})
+	var result *StreamResult
+	var err error
+	go func() {
+		defer close(done)
+		result, err = RunServerSession(&RunServerOptions{
+			Client:         NewServerClient(srv.URL),
+			SessionID:      "s1",
+			Prompt:         "do the thing",
+			Model:          "anthropic/claude-sonnet",
+			Quiet:          true,
+			IterationStart: time.Now(),
+			Timeout:        time.Hour,
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunServerSession did not return")
+	}
+	return result, err
+}
+
+func TestRunServerSessionIdle(t *testing.T) {
+	f := &fakeServer{events: []string{
+		`{"type":"message.updated","properties":{"info":{"id":"u1","sessionID":"s1","role":"user"}}}`,
+		`{"type":"message.part.updated","properties":{"part":{"id":"p0","type":"text","sessionID":"s1","messageID":"u1","text":"do the thing","time":{"end":1}}}}`,
+		`{"type":"message.part.updated","properties":{"part":{"id":"p1","type":"text","sessionID":"s1","messageID":"a1","text":"working on it","time":{"start":1}}}}`,
+		`{"type":"message.part.updated","properties":{"part":{"id":"p1","type":"text","sessionID":"s1","messageID":"a1","text":"working on it","time":{"start":1,"end":2}}}}`,
+		`{"type":"message.part.updated","properties":{"part":{"id":"p2","type":"tool","sessionID":"s1","messageID":"a1","tool":"bash","state":{"status":"completed","title":"ls"}}}}`,
+		`{"type":"message.part.updated","properties":{"part":{"id":"p9","type":"text","sessionID":"s2","messageID":"a9","text":"other session","time":{"end":2}}}}`,
+		`{"type":"message.part.updated","properties":{"part":{"id":"p3","type":"step-finish","sessionID":"s1","messageID":"a1","cost":0.01,"tokens":{"input":100,"output":20,"cache":{"read":5}}}}}`,
+		`{"type":"session.idle","properties":{"sessionID":"s2"}}`,
+		`{"type":"session.idle","properties":{"sessionID":"s1"}}`,
+	}}
+	result, err := runFakeSession(t, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.StdoutText != "working on it\n" {
+		t.Errorf("StdoutText = %q, want the assistant text only", result.StdoutText)
+	}
+	if result.ToolCounts["Bash"] != 1 {
+		t.Errorf("ToolCounts = %v, want one Bash call", result.ToolCounts)
+	}
+	want := TokenUsage{Input: 100, Output: 20, CacheRead: 5}
+	if result.Usage != want || result.Cost != 0.01 {
+		t.Errorf("usage = %+v, cost %v; want %+v, cost 0.01", result.Usage, result.Cost, want)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parts, _ := f.prompt["parts"].([]interface{})
+	if len(parts) != 1 || parts[0].(map[string]interface{})["text"] != "do the thing" {
+		t.Errorf("prompt parts = %v", f.prompt["parts"])
+	}
+	model, _ := f.prompt["model"].(map[string]interface{})
+	if model["providerID"] != "anthropic" || model["modelID"] != "claude-sonnet" {
+		t.Errorf("prompt model = %v", f.prompt["model"])
+	}
+}
+
+func TestRunServerSessionError(t *testing.T) {
+	f := &fakeServer{events: []string{
+		`{"type":"session.error","properties":{"sessionID":"s2","error":"not ours"}}`,
+		`{"type":"session.error","properties":{"sessionID":"s1","error":{"name":"APIError","data":{"message":"rate limit exceeded"}}}}`,
+		`{"type":"session.idle","properties":{"sessionID":"s1"}}`,
+	}}
+	result, err := runFakeSession(t, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.StderrText != "rate limit exceeded\n" {
+		t.Errorf("StderrText = %q", result.StderrText)
+	}
+	if len(result.Events) != 1 || result.Events[0].Type != EventError || result.Events[0].Error != "rate limit exceeded" {
+		t.Errorf("Events = %+v, want one error event", result.Events)
+	}
+}
+
+func TestRunServerSessionPromptRejected(t *testing.T) {
+	f := &fakeServer{promptStatus: http.StatusBadRequest}
+	_, err := runFakeSession(t, f)
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("err = %v, want the rejected prompt", err)
+	}
+}
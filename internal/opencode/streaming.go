@@ -4,8 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/wltechblog/ralphy/internal/tools"
@@ -15,84 +18,164 @@ type StreamResult struct {
 	StdoutText string
 	StderrText string
 	ToolCounts map[string]int
+	Events     []Event
+	Usage      TokenUsage
+	Cost       float64
 }
 
-func StreamProcessOutput(stdout, stderr io.Reader, compactTools bool, iterationStart time.Time, timeout time.Duration) (*StreamResult, error) {
-	toolCounts := make(map[string]int)
-	var stdoutText, stderrText strings.Builder
-
-	lastPrintedAt := time.Now()
-	lastActivityAt := time.Now()
-	lastToolSummaryAt := time.Time{}
+// ToolMatcher returns the tool name a line of agent output refers to, or ""
+// when the line is not a tool line.
+type ToolMatcher func(line string) string
 
-	toolSummaryInterval := 3 * time.Second
-	heartbeatInterval := 10 * time.Second
-
-	maybePrintToolSummary := func(force bool) {
-		if !compactTools || len(toolCounts) == 0 {
-			return
-		}
-		now := time.Now()
-		if !force && now.Sub(lastToolSummaryAt) < toolSummaryInterval {
-			return
+// RegexToolMatcher builds a ToolMatcher from a regular expression. The first
+// capture group is used as the tool name, or the whole match if there is none.
+func RegexToolMatcher(pattern string) (ToolMatcher, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tool pattern %q: %w", pattern, err)
+	}
+	return func(line string) string {
+		matches := re.FindStringSubmatch(tools.StripAnsi(line))
+		if matches == nil {
+			return ""
 		}
-		summary := tools.FormatToolSummary(toolCounts, 6)
-		if summary != "" {
-			fmt.Printf("| Tools    %s\n", summary)
-			lastPrintedAt = now
-			lastToolSummaryAt = now
+		if len(matches) > 1 {
+			return matches[1]
 		}
+		return matches[0]
+	}, nil
+}
+
+type StreamOptions struct {
+	CompactTools   bool
+	IterationStart time.Time
+	Timeout        time.Duration
+	MatchTool      ToolMatcher
+	// Heartbeat, when set, is called on every heartbeat tick while the agent
+	// is running, whether or not it produced output.
+	Heartbeat func()
+	// OnTool, when set, is called for every tool call as it is counted.
+	OnTool func(name string)
+	// OnUsage, when set, is called with the running token and cost totals
+	// whenever a step finishes.
+	OnUsage func(usage TokenUsage, cost float64)
+	// Cancel aborts the stream with ErrCancelled when closed.
+	Cancel <-chan struct{}
+}
+
+func StreamProcessOutput(stdout, stderr io.Reader, opts *StreamOptions) (*StreamResult, error) {
+	matchTool := opts.MatchTool
+	if matchTool == nil {
+		matchTool = toolPattern
 	}
+	compactTools := opts.CompactTools
+	m := newStreamMonitor(compactTools)
+	var stdoutText, stderrText strings.Builder
 
-	handleLine := func(line string, isError bool) {
-		lastActivityAt = time.Now()
-		toolMatch := toolPattern(line)
-		if compactTools && toolMatch != "" {
-			toolCounts[toolMatch]++
-			maybePrintToolSummary(false)
-			return
-		}
-		if line == "" {
-			fmt.Println("")
-			lastPrintedAt = time.Now()
-			return
-		}
-		if isError {
-			fmt.Fprintln(os.Stderr, line)
-		} else {
-			fmt.Println(line)
+	handleText := func(text *strings.Builder, isError bool) func(string) {
+		return func(line string) {
+			text.WriteString(line + "\n")
+			if tool := matchTool(line); tool != "" {
+				m.countTool(tool)
+				if compactTools {
+					return
+				}
+			}
+			m.printLine(line, isError)
 		}
-		lastPrintedAt = time.Now()
 	}
 
-	stream := func(r io.Reader, text *strings.Builder, isError bool) error {
+	err := m.run(stdout, stderr, handleText(&stdoutText, false), handleText(&stderrText, true), opts)
+
+	// After a cancel or timeout the readers may still be running; the
+	// result holds what they had read by then.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &StreamResult{
+		StdoutText: stdoutText.String(),
+		StderrText: stderrText.String(),
+		ToolCounts: maps.Clone(m.toolCounts),
+	}, err
+}
+
+type streamMonitor struct {
+	mu                sync.Mutex
+	compactTools      bool
+	onTool            func(name string)
+	onUsage           func(usage TokenUsage, cost float64)
+	toolCounts        map[string]int
+	lastPrintedAt     time.Time
+	lastActivityAt    time.Time
+	lastToolSummaryAt time.Time
+}
+
+const (
+	toolSummaryInterval = 3 * time.Second
+	heartbeatInterval   = 10 * time.Second
+	maxLineSize         = 16 * 1024 * 1024
+)
+
+func newStreamMonitor(compactTools bool) *streamMonitor {
+	return &streamMonitor{
+		compactTools:   compactTools,
+		toolCounts:     make(map[string]int),
+		lastPrintedAt:  time.Now(),
+		lastActivityAt: time.Now(),
+	}
+}
+
+func (m *streamMonitor) countTool(name string) {
+	m.toolCounts[name]++
+	if m.onTool != nil {
+		m.onTool(name)
+	}
+	m.maybePrintToolSummary(false)
+}
+
+func (m *streamMonitor) maybePrintToolSummary(force bool) {
+	if !m.compactTools || len(m.toolCounts) == 0 {
+		return
+	}
+	now := time.Now()
+	if !force && now.Sub(m.lastToolSummaryAt) < toolSummaryInterval {
+		return
+	}
+	summary := tools.FormatToolSummary(m.toolCounts, 6)
+	if summary != "" {
+		fmt.Printf("| Tools    %s\n", summary)
+		m.lastPrintedAt = now
+		m.lastToolSummaryAt = now
+	}
+}
+
+func (m *streamMonitor) printLine(line string, isError bool) {
+	if isError {
+		fmt.Fprintln(os.Stderr, line)
+	} else {
+		fmt.Println(line)
+	}
+	m.lastPrintedAt = time.Now()
+}
+
+// run pumps both readers line by line into the handlers, printing a
+// heartbeat while the agent is quiet and failing once it has been inactive
+// for longer than timeout.
+func (m *streamMonitor) run(stdout, stderr io.Reader, handleStdout, handleStderr func(string), opts *StreamOptions) error {
+	m.onTool = opts.OnTool
+	m.onUsage = opts.OnUsage
+	stream := func(r io.Reader, handle func(string)) error {
 		if r == nil {
 			return nil
 		}
 		scanner := bufio.NewScanner(r)
-		var buffer string
-
+		scanner.Buffer(make([]byte, 64*1024), maxLineSize)
 		for scanner.Scan() {
-			chunk := scanner.Text()
-			text.WriteString(chunk)
-			buffer += chunk + "\n"
-			lines := strings.Split(buffer, "\n")
-			buffer = lines[len(lines)-1]
-
-			for i := 0; i < len(lines)-1; i++ {
-				handleLine(lines[i], isError)
-			}
+			m.mu.Lock()
+			m.lastActivityAt = time.Now()
+			handle(scanner.Text())
+			m.mu.Unlock()
 		}
-
-		if err := scanner.Err(); err != nil {
-			return err
-		}
-
-		if buffer != "" {
-			handleLine(buffer, isError)
-		}
-
-		return nil
+		return scanner.Err()
 	}
 
 	heartbeatTimer := time.NewTicker(heartbeatInterval)
@@ -102,14 +185,14 @@ func StreamProcessOutput(stdout, stderr io.Reader, compactTools bool, iterationS
 	errChan := make(chan error, 2)
 
 	go func() {
-		if err := stream(stdout, &stdoutText, false); err != nil {
+		if err := stream(stdout, handleStdout); err != nil {
 			errChan <- err
 		}
 		done <- true
 	}()
 
 	go func() {
-		if err := stream(stderr, &stderrText, true); err != nil {
+		if err := stream(stderr, handleStderr); err != nil {
 			errChan <- err
 		}
 		done <- true
@@ -121,26 +204,31 @@ func StreamProcessOutput(stdout, stderr io.Reader, compactTools bool, iterationS
 		case <-done:
 			doneCount++
 			if doneCount == 2 {
-				return &StreamResult{
-					StdoutText: stdoutText.String(),
-					StderrText: stderrText.String(),
-					ToolCounts: toolCounts,
-				}, nil
+				return nil
 			}
 		case err := <-errChan:
-			return nil, err
+			return err
+		case <-opts.Cancel:
+			return ErrCancelled
 		case <-heartbeatTimer.C:
+			if opts.Heartbeat != nil {
+				opts.Heartbeat()
+			}
+
+			m.mu.Lock()
 			now := time.Now()
-			if timeout > 0 && now.Sub(lastActivityAt) > timeout {
-				return nil, fmt.Errorf("inactivity timeout (%v) reached", timeout)
+			if opts.Timeout > 0 && now.Sub(m.lastActivityAt) > opts.Timeout {
+				m.mu.Unlock()
+				return fmt.Errorf("%w (%v) reached", ErrInactivityTimeout, opts.Timeout)
 			}
 
-			if now.Sub(lastPrintedAt) >= heartbeatInterval {
-				elapsed := tools.FormatDuration(now.Sub(iterationStart).Milliseconds())
-				sinceActivity := tools.FormatDuration(now.Sub(lastActivityAt).Milliseconds())
+			if now.Sub(m.lastPrintedAt) >= heartbeatInterval {
+				elapsed := tools.FormatDuration(now.Sub(opts.IterationStart).Milliseconds())
+				sinceActivity := tools.FormatDuration(now.Sub(m.lastActivityAt).Milliseconds())
 				fmt.Printf("⏳ working... elapsed %s · last activity %s ago\n", elapsed, sinceActivity)
-				lastPrintedAt = now
+				m.lastPrintedAt = now
 			}
+			m.mu.Unlock()
 		}
 	}
 }
@@ -172,7 +260,7 @@ func isToolNameSeparator(c byte) bool {
 	return c == ' ' || c == '\t' || c == '|' || c == ':'
 }
 
-func BufferProcessOutput(stdout, stderr io.Reader) (*StreamResult, error) {
+func BufferProcessOutput(stdout, stderr io.Reader, matchTool ToolMatcher) (*StreamResult, error) {
 	stdoutData, err := io.ReadAll(stdout)
 	if err != nil {
 		return nil, err
@@ -187,7 +275,20 @@ func BufferProcessOutput(stdout, stderr io.Reader) (*StreamResult, error) {
 	stderrText := string(stderrData)
 	combined := stdoutText + "\n" + stderrText
 
-	toolCounts := tools.CollectToolSummaryFromText(combined)
+	fmt.Print(stdoutText)
+	fmt.Fprint(os.Stderr, stderrText)
+
+	var toolCounts map[string]int
+	if matchTool == nil {
+		toolCounts = tools.CollectToolSummaryFromText(combined)
+	} else {
+		toolCounts = make(map[string]int)
+		for _, line := range strings.Split(combined, "\n") {
+			if tool := matchTool(line); tool != "" {
+				toolCounts[tool]++
+			}
+		}
+	}
 
 	return &StreamResult{
 		StdoutText: stdoutText,
@@ -0,0 +1,37 @@
+package opencode
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStreamProcessOutputCancelKeepsOutput(t *testing.T) {
+	stdoutR, stdoutW := io.Pipe()
+	defer stdoutW.Close()
+
+	cancel := make(chan struct{})
+	done := make(chan struct{})
+	var result *StreamResult
+	var err error
+	go func() {
+		defer close(done)
+		result, err = StreamProcessOutput(stdoutR, nil, &StreamOptions{IterationStart: time.Now(), Cancel: cancel})
+	}()
+
+	// A pipe write returns once it has been read, so the lines before the
+	// last write have been handled when it returns.
+	for _, chunk := range []string{"| Bash ls\n", "working\n", "still"} {
+		stdoutW.Write([]byte(chunk))
+	}
+	close(cancel)
+	<-done
+
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("err = %v, want ErrCancelled", err)
+	}
+	if result == nil || result.StdoutText != "| Bash ls\nworking\n" || result.ToolCounts["Bash"] != 1 {
+		t.Errorf("result = %+v, want the output read before the cancel", result)
+	}
+}
@@ -0,0 +1,154 @@
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/wltechblog/ralphy/internal/config"
+	"github.com/wltechblog/ralphy/internal/state"
+)
+
+const (
+	ProjectFileName = ".ralphy-prices.json"
+	userFileName    = "prices.json"
+	SourceBuiltin   = "built-in"
+)
+
+// Price is what a model charges, in US dollars per million tokens.
+// Reasoning tokens are billed as output.
+type Price struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheRead  float64 `json:"cacheRead,omitempty"`
+	CacheWrite float64 `json:"cacheWrite,omitempty"`
+	// Source is the file the price came from.
+	Source string `json:"-"`
+}
+
+// Cost prices usage.
+func (p Price) Cost(u state.TokenUsage) float64 {
+	return (float64(u.Input)*p.Input +
+		float64(u.Output+u.Reasoning)*p.Output +
+		float64(u.CacheRead)*p.CacheRead +
+		float64(u.CacheWrite)*p.CacheWrite) / 1000000
+}
+
+// Table maps model names to prices. A key without a provider, such as
+// "claude-sonnet-4", matches the model under any provider, and a key matches
+// the model's dated releases; see Lookup.
+type Table map[string]Price
+
+// builtin holds list prices at the time of writing. They go out of date;
+// prices.json overrides them.
+var builtin = Table{
+	"claude-opus-4":     {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"claude-sonnet-4":   {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-3-7-sonnet": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-3-5-haiku":  {Input: 0.8, Output: 4, CacheRead: 0.08, CacheWrite: 1},
+	"gpt-4.1":           {Input: 2, Output: 8, CacheRead: 0.5},
+	"gpt-4.1-mini":      {Input: 0.4, Output: 1.6, CacheRead: 0.1},
+	"gpt-4o":            {Input: 2.5, Output: 10, CacheRead: 1.25},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.6, CacheRead: 0.075},
+	"o3":                {Input: 2, Output: 8, CacheRead: 0.5},
+	"o4-mini":           {Input: 1.1, Output: 4.4, CacheRead: 0.275},
+	"gemini-2.5-pro":    {Input: 1.25, Output: 10, CacheRead: 0.31},
+	"gemini-2.5-flash":  {Input: 0.3, Output: 2.5, CacheRead: 0.075},
+}
+
+// Builtin returns a copy of the built-in price table.
+func Builtin() Table {
+	t := Table{}
+	for model, price := range builtin {
+		price.Source = SourceBuiltin
+		t[model] = price
+	}
+	return t
+}
+
+// UserFilePath returns prices.json next to the user config file.
+func UserFilePath() (string, error) {
+	path, err := config.UserConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), userFileName), nil
+}
+
+// Load returns the built-in prices overridden by the user's prices.json and
+// then the project's .ralphy-prices.json.
+func Load() (Table, error) {
+	t := Builtin()
+	var paths []string
+	if userPath, err := UserFilePath(); err == nil {
+		paths = append(paths, userPath)
+	}
+	paths = append(paths, ProjectFileName)
+
+	for _, path := range paths {
+		prices, err := loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for model, price := range prices {
+			price.Source = path
+			t[strings.ToLower(model)] = price
+		}
+	}
+	return t, nil
+}
+
+func loadFile(path string) (Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var t Table
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("invalid price table %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// versionSuffix matches the release tags that name a snapshot of a model
+// rather than another model, such as "-20250514", "@20250514",
+// "-2025-04-14" or "-latest".
+var versionSuffix = regexp.MustCompile(`(?:[-@](?:\d{8}|\d{4}-\d{2}-\d{2})|-latest)$`)
+
+// Lookup finds the price of model by its exact name, with or without the
+// provider and with or without a version suffix. Other models are not
+// priced, even when a key is a prefix of their name: "o3" says nothing about
+// "o3-mini".
+func (t Table) Lookup(model string) (Price, bool) {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if model == "" {
+		return Price{}, false
+	}
+	base := versionSuffix.ReplaceAllString(model, "")
+	for _, candidate := range []string{model, base} {
+		if price, ok := t[candidate]; ok {
+			return price, true
+		}
+		if price, ok := t[candidate[strings.LastIndex(candidate, "/")+1:]]; ok {
+			return price, true
+		}
+	}
+	return Price{}, false
+}
+
+// Models returns the models in the table, sorted.
+func (t Table) Models() []string {
+	models := make([]string, 0, len(t))
+	for model := range t {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
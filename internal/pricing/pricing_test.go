@@ -0,0 +1,77 @@
+package pricing
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	table := Builtin()
+	table["openrouter/o3"] = Price{Input: 9, Output: 9}
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{model: "claude-sonnet-4", want: "claude-sonnet-4"},
+		{model: "anthropic/claude-sonnet-4-20250514", want: "claude-sonnet-4"},
+		{model: "vertex/claude-opus-4@20250514", want: "claude-opus-4"},
+		{model: " OpenAI/GPT-4.1-2025-04-14 ", want: "gpt-4.1"},
+		{model: "openai/gpt-4.1-mini", want: "gpt-4.1-mini"},
+		{model: "google/gemini-2.5-pro-latest", want: "gemini-2.5-pro"},
+		{model: "openai/o3", want: "o3"},
+		{model: "openrouter/o3-20250416", want: "openrouter/o3"},
+		{model: "o3-mini"},
+		{model: "openai/gpt-4.1-nano"},
+		{model: "claude-sonnet-4-5"},
+		{model: "gpt-4.1-2025"},
+		{model: ""},
+	}
+	for _, tt := range tests {
+		price, ok := table.Lookup(tt.model)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("Lookup(%q) = %+v, want unpriced", tt.model, price)
+			}
+			continue
+		}
+		if !ok || price != table[tt.want] {
+			t.Errorf("Lookup(%q) = %+v, %v; want %s %+v", tt.model, price, ok, tt.want, table[tt.want])
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Chdir(t.TempDir())
+
+	userPath := filepath.Join(configDir, "ralphy", userFileName)
+	os.MkdirAll(filepath.Dir(userPath), 0755)
+	os.WriteFile(userPath, []byte(`{"Claude-Sonnet-4": {"input": 1, "output": 2}, "my-model": {"input": 3, "output": 4}}`), 0644)
+	os.WriteFile(ProjectFileName, []byte(`{"my-model": {"input": 5, "output": 6, "cacheRead": 0.5}}`), 0644)
+
+	table, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		model string
+		want  Price
+	}{
+		{model: "claude-sonnet-4", want: Price{Input: 1, Output: 2, Source: userPath}},
+		{model: "my-model", want: Price{Input: 5, Output: 6, CacheRead: 0.5, Source: ProjectFileName}},
+		{model: "gpt-4o", want: Price{Input: 2.5, Output: 10, CacheRead: 1.25, Source: SourceBuiltin}},
+	}
+	for _, tt := range tests {
+		if got := table[tt.model]; got != tt.want {
+			t.Errorf("%s = %+v, want %+v", tt.model, got, tt.want)
+		}
+	}
+
+	os.WriteFile(ProjectFileName, []byte(`{"my-model": {"input": "cheap"}}`), 0644)
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid price table "+ProjectFileName) {
+		t.Errorf("Load with an invalid table: err = %v", err)
+	}
+}
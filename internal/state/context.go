@@ -41,11 +41,14 @@ func LoadContext() (string, error) {
 	return trimmed, nil
 }
 
+// SaveContext appends a timestamped entry to the pending context.
 func SaveContext(context string) error {
-	if err := EnsureStateDir(); err != nil {
-		return err
-	}
+	return withWriteLock(func() error {
+		return appendContext(context)
+	})
+}
 
+func appendContext(context string) error {
 	contextPath, err := GetContextPath()
 	if err != nil {
 		return err
@@ -66,10 +69,14 @@ func SaveContext(context string) error {
 		content = "# Ralph Loop Context\n" + newEntry
 	}
 
-	return os.WriteFile(contextPath, []byte(content), 0644)
+	return writeFileAtomic(contextPath, []byte(content), 0644)
 }
 
 func ClearContext() error {
+	return withWriteLock(removeContext)
+}
+
+func removeContext() error {
 	contextPath, err := GetContextPath()
 	if err != nil {
 		return err
@@ -80,3 +87,29 @@ func ClearContext() error {
 	}
 	return nil
 }
+
+// ConsumeContext removes the context an iteration was given, as returned by
+// LoadContext, while keeping any entries added after it was read.
+func ConsumeContext(consumed string) error {
+	return withWriteLock(func() error {
+		current, err := LoadContext()
+		if err != nil {
+			return err
+		}
+		if current == consumed || current == "" {
+			return removeContext()
+		}
+		if !strings.HasPrefix(current, consumed) {
+			// The context was cleared and written again since the
+			// iteration read it; all of it is new.
+			return nil
+		}
+
+		contextPath, err := GetContextPath()
+		if err != nil {
+			return err
+		}
+		remaining := strings.TrimLeft(strings.TrimPrefix(current, consumed), "\n")
+		return writeFileAtomic(contextPath, []byte("# Ralph Loop Context\n\n"+remaining+"\n"), 0644)
+	})
+}
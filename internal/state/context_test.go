@@ -0,0 +1,174 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	writerProcessEnv = "RALPHY_TEST_CONTEXT_WRITER"
+	hintsPerWriter   = 25
+)
+
+var hintPattern = regexp.MustCompile(`hint-\d+-\d+`)
+
+// TestContextWriterProcess is the body of the writer processes started by
+// TestSaveContextConcurrentWithConsumer; on its own it does nothing.
+func TestContextWriterProcess(t *testing.T) {
+	id := os.Getenv(writerProcessEnv)
+	if id == "" {
+		t.Skip("helper process")
+	}
+	for i := 0; i < hintsPerWriter; i++ {
+		if err := SaveContext(fmt.Sprintf("hint-%s-%d", id, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestSaveContextConcurrentWithConsumer appends hints from many goroutines
+// and processes while a consumer repeatedly reads and consumes the context,
+// the way the loop does between iterations. Every hint must be seen exactly
+// once.
+func TestSaveContextConcurrentWithConsumer(t *testing.T) {
+	if os.Getenv(writerProcessEnv) != "" {
+		t.Skip("helper process")
+	}
+	t.Chdir(t.TempDir())
+
+	const goroutines = 8
+	const processes = 4
+
+	var writers sync.WaitGroup
+	errs := make(chan error, goroutines+processes)
+	for g := 0; g < goroutines; g++ {
+		writers.Add(1)
+		go func(id int) {
+			defer writers.Done()
+			for i := 0; i < hintsPerWriter; i++ {
+				if err := SaveContext(fmt.Sprintf("hint-%d-%d", id, i)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(g)
+	}
+	for p := 0; p < processes; p++ {
+		writers.Add(1)
+		go func(id int) {
+			defer writers.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestContextWriterProcess$")
+			cmd.Env = append(os.Environ(), writerProcessEnv+"="+strconv.Itoa(id))
+			if output, err := cmd.CombinedOutput(); err != nil {
+				errs <- fmt.Errorf("writer process %d: %v\n%s", id, err, output)
+			}
+		}(goroutines + p)
+	}
+
+	writersDone := make(chan struct{})
+	go func() {
+		writers.Wait()
+		close(writersDone)
+	}()
+
+	seen := map[string]int{}
+	consume := func() {
+		context, err := LoadContext()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if context == "" {
+			return
+		}
+		if err := ConsumeContext(context); err != nil {
+			t.Fatal(err)
+		}
+		for _, hint := range hintPattern.FindAllString(context, -1) {
+			seen[hint]++
+		}
+	}
+
+	for done := false; !done; {
+		select {
+		case <-writersDone:
+			done = true
+		default:
+			consume()
+			time.Sleep(time.Millisecond)
+		}
+	}
+	consume()
+
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	want := (goroutines + processes) * hintsPerWriter
+	if len(seen) != want {
+		t.Errorf("saw %d distinct hints, want %d", len(seen), want)
+	}
+	for hint, count := range seen {
+		if count != 1 {
+			t.Errorf("%s consumed %d times", hint, count)
+		}
+	}
+}
+
+// TestSaveStateIsAtomic checks that a reader never observes a partially
+// written state file while it is being rewritten.
+func TestSaveStateIsAtomic(t *testing.T) {
+	if os.Getenv(writerProcessEnv) != "" {
+		t.Skip("helper process")
+	}
+	t.Chdir(t.TempDir())
+
+	s := &RalphState{Active: true, Prompt: string(make([]byte, 256*1024))}
+	if err := SaveState(s); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				snapshot := *s
+				snapshot.Iteration = w*100000 + i
+				if err := SaveState(&snapshot); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		path, _ := getStatePath()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loaded RalphState
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			t.Fatalf("read a partial state file: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
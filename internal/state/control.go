@@ -0,0 +1,96 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	ControlStop  = "stop"
+	ControlPause = "pause"
+	ControlAbort = "abort"
+)
+
+// ControlRequest asks the running loop to change course. It is written by
+// `ralphy --stop/--pause/--abort` in another terminal and polled by the loop.
+type ControlRequest struct {
+	Action      string `json:"action"`
+	RequestedAt string `json:"requestedAt"`
+	RequestedBy int    `json:"requestedBy"`
+}
+
+func getControlPath() (string, error) {
+	stateDir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, controlFileName), nil
+}
+
+func LoadControl() (*ControlRequest, error) {
+	controlPath, err := getControlPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(controlPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var req ControlRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// RequestControl records a control request. Stop and abort take precedence
+// over a pending pause; a pause never replaces a pending stop or abort.
+func RequestControl(action string) error {
+	return withWriteLock(func() error {
+		return requestControl(action)
+	})
+}
+
+func requestControl(action string) error {
+	existing, _ := LoadControl()
+	if existing != nil && action == ControlPause && existing.Action != ControlPause {
+		return nil
+	}
+
+	if err := ensureStateDir(); err != nil {
+		return err
+	}
+	controlPath, err := getControlPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(&ControlRequest{
+		Action:      action,
+		RequestedAt: time.Now().Format(time.RFC3339),
+		RequestedBy: os.Getpid(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(controlPath, data, 0644)
+}
+
+func ClearControl() error {
+	controlPath, err := getControlPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(controlPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Finding is a problem ralphy doctor found in the state directory. Action
+// describes the repair, or what to do by hand when it is not Repairable;
+// Fixed is set once the repair has been applied.
+type Finding struct {
+	File       string `json:"file"`
+	Problem    string `json:"problem"`
+	Action     string `json:"action,omitempty"`
+	Repairable bool   `json:"repairable"`
+	Fixed      bool   `json:"fixed"`
+}
+
+type doctor struct {
+	dir      string
+	fix      bool
+	findings []Finding
+	runID    string
+}
+
+func (d *doctor) report(file, problem, action string, repair func() error) {
+	f := Finding{File: file, Problem: problem, Action: action, Repairable: repair != nil}
+	if d.fix && repair != nil {
+		if err := repair(); err != nil {
+			f.Action = fmt.Sprintf("%s failed: %v", action, err)
+		} else {
+			f.Fixed = true
+		}
+	}
+	d.findings = append(d.findings, f)
+}
+
+// Doctor validates the files in the state directory and, when fix is set,
+// repairs what it can: corrupt files are restored from their backup, rebuilt
+// from the event log or moved aside, old schemas are migrated and derived
+// values are recomputed. It must not run while a loop is active.
+func Doctor(fix bool) ([]Finding, error) {
+	dir, err := GetStateDir()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	d := &doctor{dir: dir, fix: fix}
+	err = withWriteLock(func() error {
+		d.checkState()
+		d.checkHistory()
+		d.checkControl()
+		d.checkEvents()
+		d.checkTempFiles()
+		d.checkRuns()
+		return nil
+	})
+	return d.findings, err
+}
+
+func (d *doctor) path(name string) string {
+	return filepath.Join(d.dir, name)
+}
+
+// moveAside renames a file that cannot be repaired so it stops being read
+// but is not lost.
+func moveAside(path string) error {
+	return os.Rename(path, path+".corrupt-"+time.Now().Format("20060102-150405"))
+}
+
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// restoreBackup replaces path with its backup, which the caller has checked.
+func restoreBackup(path string) error {
+	data, err := os.ReadFile(path + backupSuffix)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+func (d *doctor) checkState() {
+	path := d.path(stateFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return
+	}
+
+	var s RalphState
+	if err := readJSONFile(path, &s); err != nil {
+		var backup RalphState
+		if readJSONFile(path+backupSuffix, &backup) == nil {
+			d.report(stateFileName, fmt.Sprintf("unreadable: %v", err), "restore from "+stateFileName+backupSuffix, func() error {
+				return restoreBackup(path)
+			})
+		} else {
+			d.report(stateFileName, fmt.Sprintf("unreadable: %v", err), "move it aside (the loop cannot be resumed)", func() error {
+				return moveAside(path)
+			})
+		}
+		return
+	}
+	d.runID = s.RunID
+
+	if s.SchemaVersion > StateSchemaVersion {
+		d.report(stateFileName, fmt.Sprintf("schema %d is newer than this ralphy supports (%d)", s.SchemaVersion, StateSchemaVersion), "upgrade ralphy", nil)
+		return
+	}
+
+	var problems []string
+	if s.SchemaVersion < StateSchemaVersion {
+		problems = append(problems, fmt.Sprintf("schema %d is out of date", s.SchemaVersion))
+	}
+	if s.Iteration < 1 {
+		problems = append(problems, fmt.Sprintf("invalid iteration %d", s.Iteration))
+	}
+	if _, err := time.Parse(time.RFC3339, s.StartedAt); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid start time %q", s.StartedAt))
+	}
+	if strings.TrimSpace(s.Prompt) == "" {
+		d.report(stateFileName, "the loop has no prompt and cannot be resumed", "move it aside", func() error {
+			return moveAside(path)
+		})
+		return
+	}
+	if len(problems) == 0 {
+		return
+	}
+
+	d.report(stateFileName, strings.Join(problems, "; "), "migrate and rewrite it", func() error {
+		migrateState(&s)
+		if s.Iteration < 1 {
+			s.Iteration = 1
+		}
+		if _, err := time.Parse(time.RFC3339, s.StartedAt); err != nil {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			s.StartedAt = info.ModTime().Format(time.RFC3339)
+		}
+		EnsureRunID(&s)
+		return SaveState(&s)
+	})
+}
+
+func (d *doctor) checkHistory() {
+	path := d.path(historyFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return
+	}
+
+	var h RalphHistory
+	if err := readJSONFile(path, &h); err != nil {
+		problem := fmt.Sprintf("unreadable: %v", err)
+		var backup RalphHistory
+		switch {
+		case readJSONFile(path+backupSuffix, &backup) == nil:
+			d.report(historyFileName, problem, "restore from "+historyFileName+backupSuffix, func() error {
+				return restoreBackup(path)
+			})
+		case d.runID != "":
+			d.report(historyFileName, problem, "rebuild it from "+eventsFileName, func() error {
+				rebuilt, err := RebuildHistory(d.runID)
+				if err != nil {
+					return err
+				}
+				if err := moveAside(path); err != nil {
+					return err
+				}
+				return SaveHistory(rebuilt)
+			})
+		default:
+			d.report(historyFileName, problem, "move it aside", func() error {
+				return moveAside(path)
+			})
+		}
+		return
+	}
+
+	if h.SchemaVersion > HistorySchemaVersion {
+		d.report(historyFileName, fmt.Sprintf("schema %d is newer than this ralphy supports (%d)", h.SchemaVersion, HistorySchemaVersion), "upgrade ralphy", nil)
+		return
+	}
+
+	var problems []string
+	if h.SchemaVersion < HistorySchemaVersion {
+		problems = append(problems, fmt.Sprintf("schema %d is out of date", h.SchemaVersion))
+	} else {
+		var total int64
+		for _, iter := range h.Iterations {
+			total += iter.DurationMs
+		}
+		if total != h.TotalDurationMs {
+			problems = append(problems, fmt.Sprintf("total duration %dms does not match its iterations (%dms)", h.TotalDurationMs, total))
+		}
+	}
+	if h.Iterations == nil || h.StruggleIndicators.RepeatedErrors == nil {
+		problems = append(problems, "missing fields")
+	}
+	if len(problems) == 0 {
+		return
+	}
+
+	d.report(historyFileName, strings.Join(problems, "; "), "migrate and rewrite it", func() error {
+		migrateHistory(&h)
+		normalizeHistory(&h)
+		recomputeTotals(&h)
+		return SaveHistory(&h)
+	})
+}
+
+func (d *doctor) checkControl() {
+	path := d.path(controlFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return
+	}
+
+	var req ControlRequest
+	if err := readJSONFile(path, &req); err != nil {
+		d.report(controlFileName, fmt.Sprintf("unreadable: %v", err), "remove it", func() error {
+			return os.Remove(path)
+		})
+		return
+	}
+	switch req.Action {
+	case ControlStop, ControlPause, ControlAbort:
+	default:
+		d.report(controlFileName, fmt.Sprintf("unknown action %q", req.Action), "remove it", func() error {
+			return os.Remove(path)
+		})
+	}
+}
+
+func (d *doctor) checkEvents() {
+	path := d.path(eventsFileName)
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+
+	var good []string
+	bad := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var e Event
+		if json.Unmarshal([]byte(line), &e) != nil {
+			bad++
+			continue
+		}
+		good = append(good, line)
+	}
+	scanErr := scanner.Err()
+	f.Close()
+
+	if scanErr != nil {
+		d.report(eventsFileName, fmt.Sprintf("unreadable: %v", scanErr), "", nil)
+		return
+	}
+	if bad == 0 {
+		return
+	}
+	d.report(eventsFileName, fmt.Sprintf("%d unreadable line(s), e.g. cut short by a crash", bad), "drop them", func() error {
+		return writeFileAtomic(path, []byte(strings.Join(good, "\n")+"\n"), 0644)
+	})
+}
+
+// checkTempFiles removes temporary files left behind by a write that was
+// interrupted before its rename.
+func (d *doctor) checkTempFiles() {
+	matches, _ := filepath.Glob(d.path(".*.tmp-*"))
+	for _, path := range matches {
+		path := path
+		d.report(filepath.Base(path), "leftover temporary file from an interrupted write", "delete it", func() error {
+			return os.Remove(path)
+		})
+	}
+}
+
+func (d *doctor) checkRuns() {
+	runsDir := d.path(runsDirName)
+	entries, err := os.ReadDir(runsDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		var outcome RunOutcome
+		if err := readJSONFile(filepath.Join(runsDir, entry.Name(), runOutcomeFileName), &outcome); err != nil {
+			d.report(filepath.Join(runsDirName, entry.Name(), runOutcomeFileName), fmt.Sprintf("unreadable: %v", err), "the run is hidden from 'ralphy runs'", nil)
+		}
+	}
+}
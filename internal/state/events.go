@@ -0,0 +1,226 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	eventsFileName    = "ralph-events.jsonl"
+	runEventsFileName = "events.jsonl"
+	eventTimeFormat   = "2006-01-02T15:04:05.000Z07:00"
+)
+
+const (
+	EventLoopStarted       = "loop_started"
+	EventIterationStarted  = "iteration_started"
+	EventToolCall          = "tool_call"
+	EventCheckResult       = "check_result"
+	EventCommitCreated     = "commit_created"
+	EventContextConsumed   = "context_consumed"
+	EventPromiseDetected   = "promise_detected"
+	EventIterationFinished = "iteration_finished"
+	EventAgentFailure      = "agent_failure"
+	EventModelChanged      = "model_changed"
+	EventLoopFinished      = "loop_finished"
+)
+
+// Event is one line of ralph-events.jsonl. Only the fields that apply to
+// its type are set.
+type Event struct {
+	Time      string `json:"time"`
+	RunID     string `json:"runId"`
+	Type      string `json:"type"`
+	Iteration int    `json:"iteration,omitempty"`
+
+	// loop_started
+	Resumed       bool   `json:"resumed,omitempty"`
+	Agent         string `json:"agent,omitempty"`
+	Model         string `json:"model,omitempty"`
+	MaxIterations int    `json:"maxIterations,omitempty"`
+
+	// tool_call; Count is above 1 when the backend only reported a total
+	Tool  string `json:"tool,omitempty"`
+	Count int    `json:"count,omitempty"`
+
+	// check_result; Kind is "check" or "verify"
+	Kind  string       `json:"kind,omitempty"`
+	Check *CheckResult `json:"check,omitempty"`
+
+	// commit_created
+	Commit  string `json:"commit,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// context_consumed
+	Context string `json:"context,omitempty"`
+
+	// promise_detected; Promise is "completion" or "task"
+	Promise  string `json:"promise,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Rejected bool   `json:"rejected,omitempty"`
+
+	// iteration_finished
+	Record   *IterationHistory   `json:"record,omitempty"`
+	Struggle *StruggleIndicators `json:"struggleIndicators,omitempty"`
+
+	// agent_failure
+	Failure *AgentFailure `json:"failure,omitempty"`
+
+	// model_changed; the new model is in Model
+	PreviousModel string `json:"previousModel,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+
+	// loop_finished
+	Outcome    string `json:"outcome,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+}
+
+func GetEventsPath() (string, error) {
+	stateDir, err := GetStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, eventsFileName), nil
+}
+
+// EnsureRunID gives a loop that predates run ids one, derived from its
+// start time.
+func EnsureRunID(s *RalphState) {
+	if s.RunID != "" {
+		return
+	}
+	startedAt, err := time.Parse(time.RFC3339, s.StartedAt)
+	if err != nil {
+		startedAt = time.Now()
+	}
+	s.RunID = NewRunID(startedAt)
+}
+
+// AppendEvent stamps e with the current time and appends it to the event
+// log as a single line. The file is only ever appended to, so readers can
+// tail it while the loop runs.
+func AppendEvent(e *Event) error {
+	if err := ensureStateDir(); err != nil {
+		return err
+	}
+	path, err := GetEventsPath()
+	if err != nil {
+		return err
+	}
+
+	if e.Time == "" {
+		e.Time = time.Now().Format(eventTimeFormat)
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadEvents reads the event log, keeping only the events of runID unless
+// it is empty. Lines that are not valid JSON, such as a line cut short by a
+// crash, are skipped.
+func LoadEvents(runID string) ([]Event, error) {
+	path, err := GetEventsPath()
+	if err != nil {
+		return nil, err
+	}
+	return readEvents(path, runID)
+}
+
+func readEvents(path, runID string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			continue
+		}
+		if runID == "" || e.RunID == runID {
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// ReplayHistory rebuilds a loop's history from its events.
+func ReplayHistory(events []Event) *RalphHistory {
+	h := &RalphHistory{
+		Iterations: []IterationHistory{},
+		StruggleIndicators: StruggleIndicators{
+			RepeatedErrors: map[string]int{},
+		},
+	}
+	for _, e := range events {
+		switch {
+		case e.Type == EventIterationFinished && e.Record != nil:
+			AddIteration(h, e.Record)
+			if e.Struggle != nil {
+				h.StruggleIndicators = *e.Struggle
+			}
+		case e.Type == EventAgentFailure && e.Failure != nil:
+			h.AgentFailures = append(h.AgentFailures, *e.Failure)
+		}
+	}
+	return h
+}
+
+// RebuildHistory reconstructs the history of runID from the event log.
+func RebuildHistory(runID string) (*RalphHistory, error) {
+	if runID == "" {
+		return nil, fmt.Errorf("no run id to rebuild history for")
+	}
+	events, err := LoadEvents(runID)
+	if err != nil {
+		return nil, err
+	}
+	return ReplayHistory(events), nil
+}
+
+// writeRunEvents copies the events of runID into an archived run's dir.
+func writeRunEvents(dir, runID string) error {
+	events, err := LoadEvents(runID)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return writeFileAtomic(filepath.Join(dir, runEventsFileName), []byte(b.String()), 0644)
+}
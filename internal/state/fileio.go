@@ -0,0 +1,92 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	writeLockFileName = "ralph-write.lock"
+	backupSuffix      = ".bak"
+)
+
+// writeMu serialises locked sections within this process; the file lock
+// does the same across processes.
+var writeMu sync.Mutex
+
+// writeFileAtomic replaces path with data by writing a temporary file next to
+// it and renaming that over path, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// writeFileWithBackup is writeFileAtomic that first copies the current
+// file to path.bak, provided it holds valid JSON, so a bad write or
+// migration can be rolled back with `ralphy doctor --fix`. A damaged file is
+// not copied, so the backup keeps the last readable version.
+func writeFileWithBackup(path string, data []byte, perm os.FileMode) error {
+	if current, err := os.ReadFile(path); err == nil && json.Valid(current) {
+		if err := writeFileAtomic(path+backupSuffix, current, perm); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, data, perm)
+}
+
+// withWriteLock runs fn while holding the state directory's write lock.
+// Every read-modify-write of a state file that another process may also
+// change, such as appending context or editing tasks, goes through it.
+func withWriteLock(fn func() error) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	if err := ensureStateDir(); err != nil {
+		return err
+	}
+	stateDir, err := getStateDir()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(stateDir, writeLockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f, true); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	return fn()
+}
@@ -0,0 +1,48 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileWithBackup(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    string
+		oldBackup  string
+		wantBackup string
+	}{
+		{name: "every overwrite", current: `{"schemaVersion": 2, "iteration": 1}`, wantBackup: `{"schemaVersion": 2, "iteration": 1}`},
+		{name: "older schema", current: `{"iteration": 1}`, wantBackup: `{"iteration": 1}`},
+		{name: "replaces the old backup", current: `{"iteration": 1}`, oldBackup: `{"iteration": 0}`, wantBackup: `{"iteration": 1}`},
+		{name: "truncated file keeps the last readable backup", current: `{"schemaVer`, oldBackup: `{"iteration": 0}`, wantBackup: `{"iteration": 0}`},
+		{name: "no current file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if tt.current != "" {
+				os.WriteFile(path, []byte(tt.current), 0644)
+			}
+			if tt.oldBackup != "" {
+				os.WriteFile(path+backupSuffix, []byte(tt.oldBackup), 0644)
+			}
+			data := `{"schemaVersion": 2, "iteration": 2}`
+			if err := writeFileWithBackup(path, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(path); string(got) != data {
+				t.Errorf("file = %q, want %q", got, data)
+			}
+			backup, err := os.ReadFile(path + backupSuffix)
+			if tt.wantBackup == "" {
+				if err == nil {
+					t.Errorf("backup written: %q", backup)
+				}
+			} else if string(backup) != tt.wantBackup {
+				t.Errorf("backup = %q, %v; want %q", backup, err, tt.wantBackup)
+			}
+		})
+	}
+}
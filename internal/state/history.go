@@ -3,6 +3,7 @@ package state
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 )
@@ -43,16 +44,12 @@ func LoadHistory() (*RalphHistory, error) {
 
 	var history RalphHistory
 	if err := json.Unmarshal(data, &history); err != nil {
-		return &RalphHistory{
-			Iterations:      []IterationHistory{},
-			TotalDurationMs: 0,
-			StruggleIndicators: StruggleIndicators{
-				RepeatedErrors:       map[string]int{},
-				NoProgressIterations: 0,
-				ShortIterations:      0,
-			},
-		}, nil
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorruptState, historyPath, err)
 	}
+	if err := migrateHistory(&history); err != nil {
+		return nil, fmt.Errorf("%s: %w", historyPath, err)
+	}
+	normalizeHistory(&history)
 
 	return &history, nil
 }
@@ -67,12 +64,13 @@ func SaveHistory(history *RalphHistory) error {
 		return err
 	}
 
+	history.SchemaVersion = HistorySchemaVersion
 	data, err := json.MarshalIndent(history, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(historyPath, data, 0644)
+	return writeFileWithBackup(historyPath, data, 0644)
 }
 
 func ClearHistory() error {
@@ -90,6 +88,10 @@ func ClearHistory() error {
 func AddIteration(history *RalphHistory, iter *IterationHistory) {
 	history.Iterations = append(history.Iterations, *iter)
 	history.TotalDurationMs += iter.DurationMs
+	if iter.Tokens != nil {
+		history.TotalTokens.Add(*iter.Tokens)
+	}
+	history.TotalCostUSD += iter.CostUSD
 }
 
 func UpdateStruggleIndicators(history *RalphHistory, iter *IterationHistory) {
@@ -0,0 +1,136 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrLockHeld = errors.New("lock is held by another process")
+)
+
+const (
+	loopLockFileName  = "ralph-loop.lock"
+	loopOwnerFileName = "ralph-loop.owner"
+
+	loopLockAttempts   = 5
+	loopLockRetryDelay = 20 * time.Millisecond
+)
+
+// FileLock is an advisory lock on a file. The operating system drops it when
+// the owning process exits, so a crashed loop never leaves a lock behind.
+type FileLock struct {
+	file *os.File
+	// ownerPath, if set, is removed on Unlock.
+	ownerPath string
+}
+
+// TryLockFile takes an exclusive lock on path without blocking, returning
+// ErrLockHeld if another process already holds it.
+func TryLockFile(path string) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, false); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileLock{file: f}, nil
+}
+
+func (l *FileLock) Unlock() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	if l.ownerPath != "" {
+		os.Remove(l.ownerPath)
+	}
+	unlockFile(l.file)
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func getLoopLockPath() (string, error) {
+	stateDir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, loopLockFileName), nil
+}
+
+// AcquireLoopLock takes the lock that marks this process as the owner of the
+// loop in the state directory. It is held until Unlock or process exit. The
+// holder's PID and host are recorded next to the lock for LoopLockOwner.
+func AcquireLoopLock() (*FileLock, error) {
+	if err := ensureStateDir(); err != nil {
+		return nil, err
+	}
+	lockPath, err := getLoopLockPath()
+	if err != nil {
+		return nil, err
+	}
+	// LoopLockHeld takes the lock for a moment to test it, so retry briefly
+	// before concluding that another loop holds it.
+	var lock *FileLock
+	for attempt := 0; ; attempt++ {
+		lock, err = TryLockFile(lockPath)
+		if !errors.Is(err, ErrLockHeld) || attempt == loopLockAttempts-1 {
+			break
+		}
+		time.Sleep(loopLockRetryDelay)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%d %s\n", os.Getpid(), hostname)
+	lock.ownerPath = filepath.Join(filepath.Dir(lockPath), loopOwnerFileName)
+	if err := writeFileAtomic(lock.ownerPath, []byte(owner), 0644); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// LoopLockHeld reports whether the loop lock is held, by trying to take it.
+// A holder that has died has released it, whatever its PID now belongs to.
+func LoopLockHeld() bool {
+	lockPath, err := getLoopLockPath()
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(lockPath); err != nil {
+		return false
+	}
+	lock, err := TryLockFile(lockPath)
+	if err != nil {
+		return errors.Is(err, ErrLockHeld)
+	}
+	lock.Unlock()
+	return false
+}
+
+// LoopLockOwner describes the process that holds the loop lock, such as
+// "PID 123 on build-host", or returns "" if none is recorded. It is for
+// display only; LoopLockHeld decides whether the lock is held.
+func LoopLockOwner() string {
+	lockPath, err := getLoopLockPath()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(lockPath), loopOwnerFileName))
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return ""
+	}
+	return fmt.Sprintf("PID %s on %s", fields[0], fields[1])
+}
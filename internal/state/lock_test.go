@@ -0,0 +1,43 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestLoopLock(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if LoopLockHeld() || LoopLockOwner() != "" {
+		t.Fatal("lock held before any loop took it")
+	}
+
+	lock, err := AcquireLoopLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	if !LoopLockHeld() {
+		t.Error("LoopLockHeld = false while the lock is held")
+	}
+	if want := fmt.Sprintf("PID %d on %s", os.Getpid(), hostname); LoopLockOwner() != want {
+		t.Errorf("LoopLockOwner = %q, want %q", LoopLockOwner(), want)
+	}
+	if _, err := TryLockFile(lock.file.Name()); err != ErrLockHeld {
+		t.Errorf("second lock: err = %v, want ErrLockHeld", err)
+	}
+
+	lock.Unlock()
+	if LoopLockHeld() {
+		t.Error("LoopLockHeld = true after Unlock")
+	}
+	if _, err := os.Stat(lock.ownerPath); !os.IsNotExist(err) {
+		t.Errorf("owner file left behind: %v", err)
+	}
+
+	// A dead holder leaves its owner file behind but not the lock.
+	os.WriteFile(lock.ownerPath, []byte(fmt.Sprintf("%d %s\n", os.Getpid(), hostname)), 0644)
+	if LoopLockHeld() {
+		t.Error("LoopLockHeld = true with only a stale owner file")
+	}
+}
@@ -0,0 +1,46 @@
+//go:build windows
+
+package state
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+func lockFile(f *os.File, wait bool) error {
+	flags := uintptr(lockfileExclusiveLock)
+	if !wait {
+		flags |= lockfileFailImmediately
+	}
+	ol := new(syscall.Overlapped)
+	r1, _, err := procLockFileEx.Call(f.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r1 == 0 {
+		if err == errorLockViolation {
+			return ErrLockHeld
+		}
+		return err
+	}
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	ol := new(syscall.Overlapped)
+	r1, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
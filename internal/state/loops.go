@@ -0,0 +1,70 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// LoopInfo describes one loop in the state directory, for `status --all`.
+type LoopInfo struct {
+	Name  string      `json:"name"`
+	Dir   string      `json:"stateDir"`
+	State *RalphState `json:"state"`
+	Owner string      `json:"owner"`
+	// Iterations counts the history entries of the loop.
+	Iterations int    `json:"iterations"`
+	Problem    string `json:"problem,omitempty"`
+}
+
+// ListLoops returns the default loop, if it has state, followed by every
+// named loop under loops/, sorted by name.
+func ListLoops() ([]LoopInfo, error) {
+	base, err := GetBaseStateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{""}
+	entries, err := os.ReadDir(filepath.Join(base, loopsDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && loopNamePattern.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names[1:])
+
+	selected := loopName
+	defer func() { loopName = selected }()
+
+	var loops []LoopInfo
+	for _, name := range names {
+		loopName = name
+		info := LoopInfo{Name: name, Owner: OwnerInactive}
+		info.Dir, _ = GetStateDir()
+
+		s, err := LoadState()
+		switch {
+		case errors.Is(err, ErrStateNotFound):
+			if name == "" {
+				continue
+			}
+		case err != nil:
+			info.Problem = err.Error()
+		default:
+			info.State = s
+			info.Owner = CheckOwner(s)
+		}
+		if h, err := LoadHistory(); err == nil {
+			info.Iterations = len(h.Iterations)
+		} else if info.Problem == "" {
+			info.Problem = err.Error()
+		}
+		loops = append(loops, info)
+	}
+	return loops, nil
+}
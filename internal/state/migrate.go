@@ -0,0 +1,86 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Schema versions of the state and history files. Files written before
+// versioning have no schemaVersion field and read as version 0.
+const (
+	StateSchemaVersion   = 1
+	HistorySchemaVersion = 1
+)
+
+var (
+	ErrCorruptState = errors.New("state file is corrupt")
+	ErrSchemaTooNew = errors.New("state file was written by a newer ralphy")
+)
+
+// stateMigrations[v] upgrades a state from version v to v+1; nil means the
+// newer version only added fields.
+var stateMigrations = []func(*RalphState){
+	// v1 added schemaVersion and runId.
+	0: nil,
+}
+
+// historyMigrations[v] upgrades a history from version v to v+1.
+var historyMigrations = []func(*RalphHistory){
+	0: migrateHistoryV0,
+}
+
+// migrateHistoryV0 recomputes the total duration, which version 0 counted
+// twice for every iteration, and fills in maps older files left null.
+func migrateHistoryV0(h *RalphHistory) {
+	recomputeTotals(h)
+	normalizeHistory(h)
+}
+
+// recomputeTotals derives the history's totals from its iterations.
+func recomputeTotals(h *RalphHistory) {
+	h.TotalDurationMs = 0
+	h.TotalTokens = TokenUsage{}
+	h.TotalCostUSD = 0
+	for _, iter := range h.Iterations {
+		h.TotalDurationMs += iter.DurationMs
+		if iter.Tokens != nil {
+			h.TotalTokens.Add(*iter.Tokens)
+		}
+		h.TotalCostUSD += iter.CostUSD
+	}
+}
+
+func migrateState(s *RalphState) error {
+	if s.SchemaVersion > StateSchemaVersion {
+		return fmt.Errorf("%w (schema %d, this version reads up to %d)", ErrSchemaTooNew, s.SchemaVersion, StateSchemaVersion)
+	}
+	for s.SchemaVersion < StateSchemaVersion {
+		if migrate := stateMigrations[s.SchemaVersion]; migrate != nil {
+			migrate(s)
+		}
+		s.SchemaVersion++
+	}
+	return nil
+}
+
+func migrateHistory(h *RalphHistory) error {
+	if h.SchemaVersion > HistorySchemaVersion {
+		return fmt.Errorf("%w (schema %d, this version reads up to %d)", ErrSchemaTooNew, h.SchemaVersion, HistorySchemaVersion)
+	}
+	for h.SchemaVersion < HistorySchemaVersion {
+		if migrate := historyMigrations[h.SchemaVersion]; migrate != nil {
+			migrate(h)
+		}
+		h.SchemaVersion++
+	}
+	return nil
+}
+
+func normalizeHistory(h *RalphHistory) {
+	if h.Iterations == nil {
+		h.Iterations = []IterationHistory{}
+	}
+	if h.StruggleIndicators.RepeatedErrors == nil {
+		h.StruggleIndicators.RepeatedErrors = map[string]int{}
+	}
+}
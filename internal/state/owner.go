@@ -0,0 +1,90 @@
+package state
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	OwnerInactive = "inactive"
+	OwnerRunning  = "running"
+	OwnerStale    = "stale"
+
+	// StaleHeartbeatAfter is how long a loop on another host may go without a
+	// heartbeat before it is considered stale.
+	StaleHeartbeatAfter = 2 * time.Minute
+)
+
+// ClaimOwnership records this process as the owner of the loop and starts
+// a new session. A previous session whose process died without EndSession
+// is counted up to its last heartbeat.
+func ClaimOwnership(s *RalphState) {
+	if s.SessionStartedAt != "" {
+		start, err := time.Parse(time.RFC3339, s.SessionStartedAt)
+		end, heartbeatErr := time.Parse(time.RFC3339, s.HeartbeatAt)
+		if err == nil && heartbeatErr == nil && end.After(start) {
+			s.ElapsedMs += end.Sub(start).Milliseconds()
+		}
+	}
+	hostname, _ := os.Hostname()
+	s.PID = os.Getpid()
+	s.Hostname = hostname
+	s.Version = VERSION
+	s.HeartbeatAt = time.Now().Format(time.RFC3339)
+	s.SessionStartedAt = s.HeartbeatAt
+}
+
+// EndSession adds the current session's wall-clock time to ElapsedMs.
+func EndSession(s *RalphState) {
+	s.ElapsedMs = Elapsed(s).Milliseconds()
+	s.SessionStartedAt = ""
+}
+
+// Elapsed returns the loop's wall-clock time across all its sessions.
+func Elapsed(s *RalphState) time.Duration {
+	elapsed := time.Duration(s.ElapsedMs) * time.Millisecond
+	if start, err := time.Parse(time.RFC3339, s.SessionStartedAt); err == nil {
+		elapsed += time.Since(start)
+	}
+	return elapsed
+}
+
+// Heartbeat refreshes the heartbeat timestamp and persists the state.
+func Heartbeat(s *RalphState) error {
+	s.HeartbeatAt = time.Now().Format(time.RFC3339)
+	return SaveState(s)
+}
+
+func HeartbeatAge(s *RalphState) time.Duration {
+	heartbeatAt, err := time.Parse(time.RFC3339, s.HeartbeatAt)
+	if err != nil {
+		return 0
+	}
+	return time.Since(heartbeatAt)
+}
+
+// CheckOwner reports whether the loop recorded in s is still being driven by
+// its owner. On the owner's host the loop lock is authoritative; for loops
+// owned by another host (e.g. a shared checkout) the heartbeat is used.
+func CheckOwner(s *RalphState) string {
+	if s == nil || !s.Active {
+		return OwnerInactive
+	}
+
+	hostname, _ := os.Hostname()
+	if s.Hostname != "" && s.Hostname != hostname {
+		if s.HeartbeatAt != "" && HeartbeatAge(s) > StaleHeartbeatAfter {
+			return OwnerStale
+		}
+		return OwnerRunning
+	}
+
+	if LoopLockHeld() {
+		return OwnerRunning
+	}
+	// Loops started by versions without the lock only recorded a PID.
+	if s.Version == "" && s.PID != os.Getpid() && ProcessAlive(s.PID) {
+		return OwnerRunning
+	}
+	return OwnerStale
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package state
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package state
+
+import (
+	"syscall"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	stillActive                    = 259
+)
+
+// ProcessAlive opens the process and checks that it has no exit code yet;
+// signals cannot probe a process on Windows.
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// Access denied means the process exists but belongs to someone else.
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
@@ -0,0 +1,256 @@
+package state
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	runsDirName        = "ralph-runs"
+	runOutcomeFileName = "outcome.json"
+	runPromptFileName  = "prompt.md"
+	runTasksFileName   = "tasks.md"
+)
+
+var (
+	ErrRunNotFound  = errors.New("run not found")
+	ErrRunAmbiguous = errors.New("run id is ambiguous")
+)
+
+// RunOutcome summarises an archived loop. It is stored as outcome.json next
+// to the loop's state, history, prompt and task list.
+type RunOutcome struct {
+	ID                string     `json:"id"`
+	Outcome           string     `json:"outcome"`
+	Detail            string     `json:"detail,omitempty"`
+	StartedAt         string     `json:"startedAt"`
+	EndedAt           string     `json:"endedAt"`
+	Iterations        int        `json:"iterations"`
+	DurationMs        int64      `json:"durationMs"`
+	Tokens            TokenUsage `json:"tokens"`
+	CostUSD           float64    `json:"costUsd"`
+	Model             string     `json:"model,omitempty"`
+	Agent             string     `json:"agent,omitempty"`
+	CompletionPromise string     `json:"completionPromise"`
+	Task              string     `json:"task"`
+	TasksTotal        int        `json:"tasksTotal"`
+	TasksComplete     int        `json:"tasksComplete"`
+}
+
+// ArchivedRun is everything kept for one run under .opencode/ralph-runs/<id>/.
+type ArchivedRun struct {
+	RunOutcome
+	Dir     string        `json:"dir"`
+	State   *RalphState   `json:"state"`
+	History *RalphHistory `json:"history"`
+	Prompt  string        `json:"prompt"`
+	Tasks   string        `json:"tasks"`
+}
+
+// NewRunID returns an id that sorts by start time, e.g. 20260102-150405-a1b2.
+func NewRunID(startedAt time.Time) string {
+	suffix := make([]byte, 2)
+	rand.Read(suffix)
+	return startedAt.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+func GetRunsDir() (string, error) {
+	stateDir, err := GetStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, runsDirName), nil
+}
+
+// ArchiveRun copies the loop's state, history, prompt, task list, transcripts
+// and events into the run's directory and records how it ended. Archiving
+// the same run again, e.g. after it was resumed, replaces the earlier
+// snapshot.
+func ArchiveRun(s *RalphState, h *RalphHistory, outcome string) (*RunOutcome, error) {
+	EnsureRunID(s)
+
+	runsDir, err := GetRunsDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(runsDir, s.RunID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	summary := &RunOutcome{
+		ID:                s.RunID,
+		Outcome:           outcome,
+		StartedAt:         s.StartedAt,
+		EndedAt:           time.Now().Format(time.RFC3339),
+		Iterations:        len(h.Iterations),
+		Model:             s.Model,
+		Agent:             s.Agent,
+		CompletionPromise: s.CompletionPromise,
+		Task:              SummariseTask(s.Prompt),
+		Detail:            s.StopDetail,
+		Tokens:            h.TotalTokens,
+		CostUSD:           h.TotalCostUSD,
+	}
+	for _, iter := range h.Iterations {
+		summary.DurationMs += iter.DurationMs
+	}
+
+	tasks, tasksContent, err := LoadTasks()
+	if err == nil {
+		summary.TasksTotal = len(tasks)
+		for _, task := range tasks {
+			if task.Status == "complete" {
+				summary.TasksComplete++
+			}
+		}
+	}
+
+	files := []struct {
+		name  string
+		value interface{}
+	}{
+		{stateFileName, s},
+		{historyFileName, h},
+		{runOutcomeFileName, summary},
+	}
+	for _, f := range files {
+		data, err := json.MarshalIndent(f.value, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, f.name), data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeFileAtomic(filepath.Join(dir, runPromptFileName), []byte(s.Prompt), 0644); err != nil {
+		return nil, err
+	}
+	if tasksContent != "" {
+		if err := writeFileAtomic(filepath.Join(dir, runTasksFileName), []byte(tasksContent), 0644); err != nil {
+			return nil, err
+		}
+	}
+	if err := copyTranscripts(filepath.Join(dir, runTranscriptsDirName)); err != nil {
+		return nil, fmt.Errorf("failed to archive transcripts: %w", err)
+	}
+	if err := writeRunEvents(dir, s.RunID); err != nil {
+		return nil, fmt.Errorf("failed to archive events: %w", err)
+	}
+
+	return summary, nil
+}
+
+// ListRuns returns the outcome of every archived run, oldest first. Run
+// directories without a readable outcome.json are skipped.
+func ListRuns() ([]RunOutcome, error) {
+	runsDir, err := GetRunsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(runsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var runs []RunOutcome
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(runsDir, entry.Name(), runOutcomeFileName))
+		if err != nil {
+			continue
+		}
+		var run RunOutcome
+		if err := json.Unmarshal(data, &run); err != nil {
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		if runs[i].StartedAt != runs[j].StartedAt {
+			return runs[i].StartedAt < runs[j].StartedAt
+		}
+		return runs[i].ID < runs[j].ID
+	})
+	return runs, nil
+}
+
+// LoadRun reads an archived run. id may be any unique prefix or suffix of a
+// run id.
+func LoadRun(id string) (*ArchivedRun, error) {
+	runs, err := ListRuns()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []RunOutcome
+	for _, run := range runs {
+		if run.ID == id {
+			matches = []RunOutcome{run}
+			break
+		}
+		if strings.HasPrefix(run.ID, id) || strings.HasSuffix(run.ID, id) {
+			matches = append(matches, run)
+		}
+	}
+	switch {
+	case id == "" || len(matches) == 0:
+		return nil, fmt.Errorf("%w: %q", ErrRunNotFound, id)
+	case len(matches) > 1:
+		return nil, fmt.Errorf("%w: %q matches %d runs", ErrRunAmbiguous, id, len(matches))
+	}
+
+	runsDir, err := GetRunsDir()
+	if err != nil {
+		return nil, err
+	}
+	run := &ArchivedRun{RunOutcome: matches[0], Dir: filepath.Join(runsDir, matches[0].ID)}
+
+	data, err := os.ReadFile(filepath.Join(run.Dir, stateFileName))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &run.State); err != nil {
+		return nil, fmt.Errorf("invalid state in run %s: %w", run.ID, err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(run.Dir, historyFileName))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &run.History); err != nil {
+		return nil, fmt.Errorf("invalid history in run %s: %w", run.ID, err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(run.Dir, runPromptFileName)); err == nil {
+		run.Prompt = string(data)
+	}
+	if data, err := os.ReadFile(filepath.Join(run.Dir, runTasksFileName)); err == nil {
+		run.Tasks = string(data)
+	}
+	return run, nil
+}
+
+// SummariseTask returns the first line of a prompt, shortened.
+func SummariseTask(prompt string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(prompt), "\n")
+	if len(line) > 80 {
+		line = line[:80] + "..."
+	}
+	return line
+}
@@ -3,15 +3,63 @@ package state
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 )
 
 var (
-	ErrStateNotFound = errors.New("state file not found")
+	ErrStateNotFound   = errors.New("state file not found")
+	ErrInvalidLoopName = errors.New("invalid loop name")
 )
 
-func GetStateDir() (string, error) {
+// baseStateDir and loopName select the loop every function in this package
+// works on. They are set once at startup from --state-dir and --name.
+var (
+	baseStateDir string
+	loopName     string
+)
+
+var loopNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// SetStateDir makes dir the state directory instead of <cwd>/.opencode. An
+// empty dir restores the default.
+func SetStateDir(dir string) error {
+	if dir == "" {
+		baseStateDir = ""
+		return nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	baseStateDir = abs
+	return nil
+}
+
+// SetLoopName selects a named loop, whose files live in their own
+// directory under loops/ in the state directory. An empty name selects the
+// default loop.
+func SetLoopName(name string) error {
+	if name != "" && !loopNamePattern.MatchString(name) {
+		return fmt.Errorf("%w %q (use letters, digits, '.', '_' and '-')", ErrInvalidLoopName, name)
+	}
+	loopName = name
+	return nil
+}
+
+// LoopName returns the name of the selected loop, empty for the default one.
+func LoopName() string {
+	return loopName
+}
+
+// GetBaseStateDir returns the state directory that holds the default loop
+// and, under loops/, the named ones.
+func GetBaseStateDir() (string, error) {
+	if baseStateDir != "" {
+		return baseStateDir, nil
+	}
 	cwd, err := os.Getwd()
 	if err != nil {
 		return "", err
@@ -19,6 +67,18 @@ func GetStateDir() (string, error) {
 	return filepath.Join(cwd, stateDirName), nil
 }
 
+// GetStateDir returns the directory of the selected loop's files.
+func GetStateDir() (string, error) {
+	base, err := GetBaseStateDir()
+	if err != nil {
+		return "", err
+	}
+	if loopName != "" {
+		return filepath.Join(base, loopsDirName, loopName), nil
+	}
+	return base, nil
+}
+
 func getStateDir() (string, error) {
 	return GetStateDir()
 }
@@ -59,7 +119,10 @@ func LoadState() (*RalphState, error) {
 
 	var state RalphState
 	if err := json.Unmarshal(data, &state); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorruptState, statePath, err)
+	}
+	if err := migrateState(&state); err != nil {
+		return nil, fmt.Errorf("%s: %w", statePath, err)
 	}
 
 	return &state, nil
@@ -75,12 +138,13 @@ func SaveState(state *RalphState) error {
 		return err
 	}
 
+	state.SchemaVersion = StateSchemaVersion
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(statePath, data, 0644)
+	return writeFileWithBackup(statePath, data, 0644)
 }
 
 func ClearState() error {
@@ -74,8 +74,34 @@ func ParseTasks(content string) []Task {
 	return tasks
 }
 
+func GetTasksPath() (string, error) {
+	stateDir, err := GetStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, tasksFileName), nil
+}
+
+// TasksDisplayPath returns the tasks file relative to the working directory
+// when it is inside it, for prompts and messages.
+func TasksDisplayPath() string {
+	path, err := GetTasksPath()
+	if err != nil {
+		return filepath.Join(stateDirName, tasksFileName)
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
 func LoadTasks() ([]Task, string, error) {
-	path := filepath.Join(stateDirName, tasksFileName)
+	path, err := GetTasksPath()
+	if err != nil {
+		return nil, "", err
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -88,11 +114,14 @@ func LoadTasks() ([]Task, string, error) {
 }
 
 func SaveTasks(content string) error {
-	if err := os.MkdirAll(stateDirName, 0755); err != nil {
+	if err := ensureStateDir(); err != nil {
+		return err
+	}
+	path, err := GetTasksPath()
+	if err != nil {
 		return err
 	}
-	path := filepath.Join(stateDirName, tasksFileName)
-	return os.WriteFile(path, []byte(content), 0644)
+	return writeFileAtomic(path, []byte(content), 0644)
 }
 
 func FindCurrentTask(tasks []Task) *Task {
@@ -126,20 +155,28 @@ func AllTasksComplete(tasks []Task) bool {
 }
 
 func AddTask(description string) error {
-	_, content, err := LoadTasks()
-	if err != nil {
-		return err
-	}
+	return withWriteLock(func() error {
+		_, content, err := LoadTasks()
+		if err != nil {
+			return err
+		}
 
-	if content == "" {
-		content = "# Ralph Tasks\n\n"
-	}
+		if content == "" {
+			content = "# Ralph Tasks\n\n"
+		}
 
-	content = strings.TrimRight(content, "\n") + "\n" + fmt.Sprintf("- [ ] %s\n", description)
-	return SaveTasks(content)
+		content = strings.TrimRight(content, "\n") + "\n" + fmt.Sprintf("- [ ] %s\n", description)
+		return SaveTasks(content)
+	})
 }
 
 func RemoveTask(index int) error {
+	return withWriteLock(func() error {
+		return removeTask(index)
+	})
+}
+
+func removeTask(index int) error {
 	tasks, content, err := LoadTasks()
 	if err != nil {
 		return err
@@ -180,11 +217,44 @@ func RemoveTask(index int) error {
 	return SaveTasks(strings.Join(newLines, "\n"))
 }
 
+// CompleteTask marks the task at index (1-based) as done.
+func CompleteTask(index int) error {
+	return withWriteLock(func() error {
+		return completeTask(index)
+	})
+}
+
+func completeTask(index int) error {
+	tasks, content, err := LoadTasks()
+	if err != nil {
+		return err
+	}
+
+	if index < 1 || index > len(tasks) {
+		return fmt.Errorf("task index %d out of range (1-%d)", index, len(tasks))
+	}
+
+	lines := strings.Split(content, "\n")
+	currentTaskLine := 0
+	for i, line := range lines {
+		if topLevelTaskRegex.MatchString(line) {
+			currentTaskLine++
+			if currentTaskLine == index {
+				lines[i] = strings.Replace(line, line[:5], "- [x]", 1)
+				break
+			}
+		}
+	}
+
+	return SaveTasks(strings.Join(lines, "\n"))
+}
+
 func GetTasksModeSection(s *RalphState) string {
 	tasks, tasksContent, err := LoadTasks()
 	if err != nil || tasksContent == "" {
-		return "\n## TASKS MODE: Enabled (no tasks file found)\n\nCreate .opencode/ralph-tasks.md with your task list, or use `ralphy --add-task \"description\"` to add tasks.\n"
+		return fmt.Sprintf("\n## TASKS MODE: Enabled (no tasks file found)\n\nCreate %s with your task list, or use `ralphy tasks add \"description\"` to add tasks.\n", TasksDisplayPath())
 	}
+	tasksPath := TasksDisplayPath()
 
 	currentTask := FindCurrentTask(tasks)
 	nextTask := FindNextTask(tasks)
@@ -194,24 +264,24 @@ func GetTasksModeSection(s *RalphState) string {
 		taskInstructions = fmt.Sprintf(`
 🔄 CURRENT TASK: "%s"
    Focus on completing this specific task.
-   When done: Mark as [x] in .opencode/ralph-tasks.md and output <promise>%s</promise>`, currentTask.Text, s.TaskPromise)
+   When done: Mark as [x] in %s and output <promise>%s</promise>`, currentTask.Text, tasksPath, s.TaskPromise)
 	} else if nextTask != nil {
 		taskInstructions = fmt.Sprintf(`
 📍 NEXT TASK: "%s"
-   Mark as [/] in .opencode/ralph-tasks.md before starting.
-   When done: Mark as [x] and output <promise>%s</promise>`, nextTask.Text, s.TaskPromise)
+   Mark as [/] in %s before starting.
+   When done: Mark as [x] and output <promise>%s</promise>`, nextTask.Text, tasksPath, s.TaskPromise)
 	} else if AllTasksComplete(tasks) {
 		taskInstructions = fmt.Sprintf(`
 ✅ ALL TASKS COMPLETE!
    Output <promise>%s</promise> to finish.`, s.CompletionPromise)
 	} else {
-		taskInstructions = "\n📋 No tasks found. Add tasks to .opencode/ralph-tasks.md or use `ralphy --add-task`"
+		taskInstructions = fmt.Sprintf("\n📋 No tasks found. Add tasks to %s or use `ralphy tasks add`", tasksPath)
 	}
 
 	return fmt.Sprintf(`
 ## TASKS MODE: Working through task list
 
-Current tasks from .opencode/ralph-tasks.md:
+Current tasks from %s:
 %s%s%s
 %s
 
@@ -224,5 +294,5 @@ Current tasks from .opencode/ralph-tasks.md:
 6. Only output <promise>%s</promise> when ALL tasks are [x].
 
 ---
-`, "```markdown\n", strings.TrimSpace(tasksContent), "\n```", taskInstructions, s.TaskPromise, s.CompletionPromise)
+`, tasksPath, "```markdown\n", strings.TrimSpace(tasksContent), "\n```", taskInstructions, s.TaskPromise, s.CompletionPromise)
 }
@@ -0,0 +1,218 @@
+package state
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	transcriptsDirName      = "ralph-transcripts"
+	runTranscriptsDirName   = "transcripts"
+	transcriptIterationName = "iteration-"
+)
+
+var ErrTranscriptNotFound = errors.New("transcript not found")
+
+// Transcript is everything exchanged with the agent in one iteration: the
+// exact prompt sent and its output, both as printed (with ANSI escapes) and
+// as plain text.
+type Transcript struct {
+	Iteration   int
+	Prompt      string
+	Stdout      string
+	Stderr      string
+	StdoutPlain string
+	StderrPlain string
+}
+
+type transcriptFile struct {
+	name  string
+	value *string
+}
+
+func (t *Transcript) files() []transcriptFile {
+	return []transcriptFile{
+		{"prompt.md", &t.Prompt},
+		{"stdout.log", &t.Stdout},
+		{"stdout.txt", &t.StdoutPlain},
+		{"stderr.log", &t.Stderr},
+		{"stderr.txt", &t.StderrPlain},
+	}
+}
+
+// GetTranscriptsDir returns the directory holding the transcripts of the
+// current loop.
+func GetTranscriptsDir() (string, error) {
+	stateDir, err := GetStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, transcriptsDirName), nil
+}
+
+// TranscriptsDir returns the directory holding an archived run's transcripts.
+func (r *ArchivedRun) TranscriptsDir() string {
+	return filepath.Join(r.Dir, runTranscriptsDirName)
+}
+
+// SaveTranscript writes the transcript of one iteration of the current loop,
+// gzip-compressing each file when compress is set. Empty outputs are skipped.
+func SaveTranscript(t *Transcript, compress bool) error {
+	transcriptsDir, err := GetTranscriptsDir()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(transcriptsDir, transcriptIterationName+strconv.Itoa(t.Iteration))
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, f := range t.files() {
+		if *f.value == "" && f.name != "prompt.md" {
+			continue
+		}
+		data := []byte(*f.value)
+		path := filepath.Join(dir, f.name)
+		if compress {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(data)
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			data = buf.Bytes()
+			path += ".gz"
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadTranscript reads the transcript of an iteration from dir, which is
+// either GetTranscriptsDir() or an archived run's TranscriptsDir().
+func LoadTranscript(dir string, iteration int) (*Transcript, error) {
+	iterDir := filepath.Join(dir, transcriptIterationName+strconv.Itoa(iteration))
+	if _, err := os.Stat(iterDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w for iteration %d", ErrTranscriptNotFound, iteration)
+		}
+		return nil, err
+	}
+
+	t := &Transcript{Iteration: iteration}
+	for _, f := range t.files() {
+		data, err := readMaybeGzipped(filepath.Join(iterDir, f.name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		*f.value = string(data)
+	}
+	return t, nil
+}
+
+// ListTranscripts returns the iterations that have a transcript in dir, in
+// ascending order.
+func ListTranscripts(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var iterations []int
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), transcriptIterationName) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), transcriptIterationName))
+		if err != nil {
+			continue
+		}
+		iterations = append(iterations, n)
+	}
+	sort.Ints(iterations)
+	return iterations, nil
+}
+
+func ClearTranscripts() error {
+	dir, err := GetTranscriptsDir()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func readMaybeGzipped(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return data, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.Open(path + ".gz")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip file %s.gz: %w", path, err)
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// copyTranscripts copies the current loop's transcripts into dst, replacing
+// whatever was there.
+func copyTranscripts(dst string) error {
+	src, err := GetTranscriptsDir()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(target, data, 0644)
+	})
+}
@@ -1,5 +1,12 @@
 package state
 
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wltechblog/ralphy/internal/tools"
+)
+
 const (
 	VERSION         = "1.0.9"
 	stateDirName    = ".opencode"
@@ -7,17 +14,82 @@ const (
 	historyFileName = "ralph-history.json"
 	contextFileName = "ralph-context.md"
 	tasksFileName   = "ralph-tasks.md"
+	controlFileName = "ralph-control.json"
+	loopsDirName    = "loops"
 )
 
 type RalphState struct {
-	Active            bool   `json:"active"`
-	Iteration         int    `json:"iteration"`
-	MaxIterations     int    `json:"maxIterations"`
-	CompletionPromise string `json:"completionPromise"`
-	TaskPromise       string `json:"taskPromise"`
-	Prompt            string `json:"prompt"`
-	StartedAt         string `json:"startedAt"`
-	Model             string `json:"model"`
+	SchemaVersion     int      `json:"schemaVersion"`
+	RunID             string   `json:"runId,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	Active            bool     `json:"active"`
+	Iteration         int      `json:"iteration"`
+	MaxIterations     int      `json:"maxIterations"`
+	CompletionPromise string   `json:"completionPromise"`
+	TaskPromise       string   `json:"taskPromise"`
+	Prompt            string   `json:"prompt"`
+	StartedAt         string   `json:"startedAt"`
+	Model             string   `json:"model"`
+	Agent             string   `json:"agent,omitempty"`
+	PID               int      `json:"pid,omitempty"`
+	Hostname          string   `json:"hostname,omitempty"`
+	Version           string   `json:"version,omitempty"`
+	HeartbeatAt       string   `json:"heartbeatAt,omitempty"`
+	Verify            []string `json:"verify,omitempty"`
+	Checks            []string `json:"checks,omitempty"`
+	Budget            *Budget  `json:"budget,omitempty"`
+	// ModelChain lists the models of --model-chain, cheapest first; Model is
+	// the one currently in use.
+	ModelChain []string `json:"modelChain,omitempty"`
+	// Branch is the branch the loop commits to, created from BaseBranch.
+	// Merge says how it goes back into BaseBranch on completion: "squash",
+	// "merge" or "" to leave it.
+	Branch     string `json:"branch,omitempty"`
+	BaseBranch string `json:"baseBranch,omitempty"`
+	Merge      string `json:"merge,omitempty"`
+	// ElapsedMs is the wall-clock time of the loop's earlier sessions and
+	// SessionStartedAt the start of the current one, so the time a loop sat
+	// stopped before --resume is not counted.
+	ElapsedMs        int64  `json:"elapsedMs,omitempty"`
+	SessionStartedAt string `json:"sessionStartedAt,omitempty"`
+	// StopReason is the outcome of the loop's last session, and StopDetail
+	// says which budget ran out when it is "budget".
+	StopReason string `json:"stopReason,omitempty"`
+	StopDetail string `json:"stopDetail,omitempty"`
+}
+
+// Budget holds the limits a loop stops at; zero means no limit. WarnAt lists
+// the percentages of a limit at which a warning is printed. Hard limits also
+// interrupt the running iteration.
+type Budget struct {
+	MaxDurationMs int64   `json:"maxDurationMs,omitempty"`
+	MaxCostUSD    float64 `json:"maxCostUsd,omitempty"`
+	MaxTokens     int64   `json:"maxTokens,omitempty"`
+	WarnAt        []int   `json:"warnAt,omitempty"`
+	Hard          bool    `json:"hard,omitempty"`
+}
+
+func (b *Budget) IsSet() bool {
+	return b != nil && (b.MaxDurationMs > 0 || b.MaxCostUSD > 0 || b.MaxTokens > 0)
+}
+
+// Summary formats the limits, e.g. "time 1h 0m 0s, cost $5.00 (hard)".
+func (b *Budget) Summary() string {
+	var parts []string
+	if b.MaxDurationMs > 0 {
+		parts = append(parts, "time "+tools.FormatDurationLong(b.MaxDurationMs))
+	}
+	if b.MaxCostUSD > 0 {
+		parts = append(parts, "cost "+tools.FormatCost(b.MaxCostUSD))
+	}
+	if b.MaxTokens > 0 {
+		parts = append(parts, "tokens "+tools.FormatTokens(b.MaxTokens))
+	}
+	s := strings.Join(parts, ", ")
+	if b.Hard {
+		s += " (hard)"
+	}
+	return s
 }
 
 type IterationHistory struct {
@@ -28,14 +100,100 @@ type IterationHistory struct {
 	ToolsUsed          map[string]int `json:"toolsUsed"`
 	FilesModified      []string       `json:"filesModified"`
 	ExitCode           int            `json:"exitCode"`
+	Model              string         `json:"model,omitempty"`
 	CompletionDetected bool           `json:"completionDetected"`
+	CompletionRejected bool           `json:"completionRejected,omitempty"`
+	TimedOut           bool           `json:"timedOut,omitempty"`
+	Verification       []CheckResult  `json:"verification,omitempty"`
+	Checks             []CheckResult  `json:"checks,omitempty"`
 	Errors             []string       `json:"errors"`
+	// Tokens is nil when the agent does not report token usage.
+	Tokens     *TokenUsage `json:"tokens,omitempty"`
+	CostUSD    float64     `json:"costUsd,omitempty"`
+	CostSource string      `json:"costSource,omitempty"`
+}
+
+// Where the cost of an iteration came from: the model price table, or the
+// agent's own report when the table has no price for the model.
+const (
+	CostSourcePrices = "prices"
+	CostSourceAgent  = "agent"
+)
+
+// TokenUsage counts the tokens of one iteration or a whole run.
+type TokenUsage struct {
+	Input      int64 `json:"input"`
+	Output     int64 `json:"output"`
+	Reasoning  int64 `json:"reasoning,omitempty"`
+	CacheRead  int64 `json:"cacheRead"`
+	CacheWrite int64 `json:"cacheWrite"`
+}
+
+func (u *TokenUsage) Add(other TokenUsage) {
+	u.Input += other.Input
+	u.Output += other.Output
+	u.Reasoning += other.Reasoning
+	u.CacheRead += other.CacheRead
+	u.CacheWrite += other.CacheWrite
+}
+
+func (u TokenUsage) Total() int64 {
+	return u.Input + u.Output + u.Reasoning + u.CacheRead + u.CacheWrite
+}
+
+// Summary formats the usage for the terminal, e.g. "12.3k in, 1.2k out".
+func (u TokenUsage) Summary() string {
+	s := fmt.Sprintf("%s in, %s out", tools.FormatTokens(u.Input), tools.FormatTokens(u.Output+u.Reasoning))
+	if u.CacheRead > 0 || u.CacheWrite > 0 {
+		s += fmt.Sprintf(", %s cache read, %s cache write", tools.FormatTokens(u.CacheRead), tools.FormatTokens(u.CacheWrite))
+	}
+	return s
+}
+
+// CheckResult is the outcome of a shell command run by the loop, such as a
+// --check or --verify command. Output holds the tail of its combined output.
+type CheckResult struct {
+	Command    string `json:"command"`
+	Passed     bool   `json:"passed"`
+	ExitCode   int    `json:"exitCode"`
+	DurationMs int64  `json:"durationMs"`
+	Output     string `json:"output,omitempty"`
 }
 
 type RalphHistory struct {
+	SchemaVersion      int                `json:"schemaVersion"`
 	Iterations         []IterationHistory `json:"iterations"`
 	TotalDurationMs    int64              `json:"totalDurationMs"`
+	TotalTokens        TokenUsage         `json:"totalTokens"`
+	TotalCostUSD       float64            `json:"totalCostUsd"`
 	StruggleIndicators StruggleIndicators `json:"struggleIndicators"`
+	AgentFailures      []AgentFailure     `json:"agentFailures,omitempty"`
+}
+
+// Kinds of agent failure: transient ones are retried, fatal ones open the
+// circuit breaker straight away.
+const (
+	FailureTransient = "transient"
+	FailureFatal     = "fatal"
+)
+
+// AgentFailure is an attempt to run the agent that failed for reasons
+// outside the task, such as a missing binary or a provider outage. It does
+// not use up an iteration.
+type AgentFailure struct {
+	Iteration int    `json:"iteration"`
+	Attempt   int    `json:"attempt"`
+	At        string `json:"at"`
+	Kind      string `json:"kind"`
+	Error     string `json:"error"`
+	// RetryInMs is the backoff before the next attempt. It is zero when the
+	// failure opened the circuit breaker and paused the loop.
+	RetryInMs     int64 `json:"retryInMs,omitempty"`
+	BreakerOpened bool  `json:"breakerOpened,omitempty"`
+	// Model is the model that failed; FallbackModel the one the loop fell
+	// back to, if --model-chain had another.
+	Model         string `json:"model,omitempty"`
+	FallbackModel string `json:"fallbackModel,omitempty"`
 }
 
 type StruggleIndicators struct {
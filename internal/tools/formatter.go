@@ -52,6 +52,26 @@ func FormatDurationLongFromTime(d time.Duration) string {
 	return FormatDurationLong(d.Milliseconds())
 }
 
+// FormatTokens abbreviates a token count, e.g. 950, 12.3k or 1.2M.
+func FormatTokens(n int64) string {
+	switch {
+	case n >= 1000000:
+		return fmt.Sprintf("%.1fM", float64(n)/1000000)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// FormatCost formats an amount in US dollars, keeping more digits for the
+// fractions of a cent a single iteration often costs.
+func FormatCost(usd float64) string {
+	if usd < 1 {
+		return fmt.Sprintf("$%.4f", usd)
+	}
+	return fmt.Sprintf("$%.2f", usd)
+}
+
 func FormatToolSummary(toolCounts map[string]int, maxItems int) string {
 	if len(toolCounts) == 0 {
 		return ""